@@ -0,0 +1 @@
+db.users.dropIndex("status_1");
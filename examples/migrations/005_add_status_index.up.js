@@ -0,0 +1,3 @@
+// index the status field that was added in migration 003
+db.users.createIndex({status: 1}, {name: "status_1"});
+db.users.updateMany({status: {$exists: false}}, {$set: {status: "active"}});
@@ -5,6 +5,7 @@ go 1.17
 require (
 	github.com/h44z/lightmigrate v1.0.0
 	go.mongodb.org/mongo-driver v1.8.2
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
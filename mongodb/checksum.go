@@ -0,0 +1,135 @@
+package mongodb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/h44z/lightmigrate"
+)
+
+// ChecksumVerification describes how the driver reacts to migration files that have been modified after they
+// were applied.
+type ChecksumVerification int
+
+const (
+	// ChecksumVerificationDisabled disables the checksum verification.
+	ChecksumVerificationDisabled ChecksumVerification = iota
+	// ChecksumVerificationWarn logs a warning for each modified migration file.
+	ChecksumVerificationWarn
+	// ChecksumVerificationFail aborts the migration with a ChecksumMismatchError if a migration file was modified.
+	ChecksumVerificationFail
+)
+
+// ChecksumMismatchError signals that already applied migration files have been modified.
+type ChecksumMismatchError struct {
+	// Versions contains all migration versions whose contents differ from the applied ones.
+	Versions []uint64
+}
+
+// Error implements error interface.
+func (e ChecksumMismatchError) Error() string {
+	versions := make([]string, len(e.Versions))
+	for i, version := range e.Versions {
+		versions[i] = strconv.FormatUint(version, 10)
+	}
+	return "checksum mismatch for applied migration versions: " + strings.Join(versions, ", ")
+}
+
+// checksum calculates the hex encoded SHA-256 hash of the migration contents.
+func checksum(contents []byte) string {
+	hash := sha256.Sum256(contents)
+	return hex.EncodeToString(hash[:])
+}
+
+// checksumField returns the field path of the checksum for the given version within the version document.
+func checksumField(version uint64) string {
+	return "checksums." + strconv.FormatUint(version, 10)
+}
+
+// verifyChecksums compares the recorded checksums of all applied migrations with the up migrations of the
+// configured source. Migrations that no longer exist in the source are ignored. Checksums of versions above the
+// applied version belong to reverted migrations, they are verified once the migration is re-applied.
+func (d *driver) verifyChecksums(recorded map[string]string, appliedVersion uint64) error {
+	if d.cfg.ChecksumVerification == ChecksumVerificationDisabled || d.checksumSource == nil {
+		return nil
+	}
+
+	var mismatches []uint64
+	for key, recordedChecksum := range recorded {
+		version, err := strconv.ParseUint(key, 10, 64)
+		if err != nil || version > appliedVersion {
+			continue // not a valid version key or not applied
+		}
+
+		contents, _, err := d.checksumSource.ReadUp(version)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return &lightmigrate.DriverError{OrigErr: err, Msg: fmt.Sprintf("failed to read migration %d", version)}
+		}
+		migr, err := ioutil.ReadAll(contents)
+		_ = contents.Close()
+		if err != nil {
+			return &lightmigrate.DriverError{OrigErr: err, Msg: fmt.Sprintf("failed to read migration %d", version)}
+		}
+
+		if checksum(migr) != recordedChecksum {
+			mismatches = append(mismatches, version)
+		}
+	}
+
+	return d.checksumMismatch(mismatches)
+}
+
+// verifyPendingChecksum compares the checksum of the migration that is about to be applied with the checksum
+// that was recorded when the same version was applied the last time. Reverting a migration keeps its checksum,
+// so that re-applied migrations can be verified.
+func (d *driver) verifyPendingChecksum(sum string) error {
+	if d.cfg.ChecksumVerification == ChecksumVerificationDisabled || d.pending == nil ||
+		d.pending.Direction != lightmigrate.Up {
+		return nil
+	}
+
+	recordedChecksum, ok := d.checksums[strconv.FormatUint(d.pending.Version, 10)]
+	if !ok || recordedChecksum == sum {
+		return nil
+	}
+
+	return d.checksumMismatch([]uint64{d.pending.Version})
+}
+
+// checksumMismatch handles the given mismatching versions according to the configured verification mode.
+func (d *driver) checksumMismatch(versions []uint64) error {
+	if len(versions) == 0 {
+		return nil
+	}
+
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	err := ChecksumMismatchError{Versions: versions}
+	if d.cfg.ChecksumVerification == ChecksumVerificationFail {
+		return err
+	}
+
+	d.logMessage("warning: %v", err)
+	return nil
+}
+
+// updateChecksums applies the checksum of the pending up migration to the locally known checksums.
+func (d *driver) updateChecksums() {
+	if d.pending == nil || d.pending.Checksum == "" || d.pending.Direction != lightmigrate.Up {
+		return
+	}
+
+	if d.checksums == nil {
+		d.checksums = make(map[string]string)
+	}
+	d.checksums[strconv.FormatUint(d.pending.Version, 10)] = d.pending.Checksum
+}
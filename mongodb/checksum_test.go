@@ -0,0 +1,234 @@
+package mongodb
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"testing"
+	"testing/fstest"
+
+	"github.com/h44z/lightmigrate"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func newChecksumTestSource(t *testing.T) lightmigrate.MigrationSource {
+	fsys := fstest.MapFS{
+		"migrations/001_first.up.json":    {Data: []byte(`[{"ping": 1}]`)},
+		"migrations/001_first.down.json":  {Data: []byte("[]")},
+		"migrations/002_second.up.json":   {Data: []byte("[{}, {}]")},
+		"migrations/002_second.down.json": {Data: []byte("[]")},
+	}
+	source, err := lightmigrate.NewFsSource(fsys, "migrations")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return source
+}
+
+func TestWithChecksumVerification(t *testing.T) {
+	d := &driver{cfg: &config{}}
+	source := newChecksumTestSource(t)
+
+	WithChecksumVerification(ChecksumVerificationFail, source)(d)
+	if d.cfg.ChecksumVerification != ChecksumVerificationFail {
+		t.Fatalf("failed to set checksum verification")
+	}
+	if d.checksumSource != source {
+		t.Fatalf("failed to set checksum source")
+	}
+}
+
+func TestChecksumMismatchError_Error(t *testing.T) {
+	err := ChecksumMismatchError{Versions: []uint64{2, 5}}
+	if err.Error() != "checksum mismatch for applied migration versions: 2, 5" {
+		t.Fatalf("unexpected error message: %s", err.Error())
+	}
+}
+
+func Test_checksum(t *testing.T) {
+	sum := checksum([]byte(`[{"ping": 1}]`))
+	if len(sum) != 64 {
+		t.Fatalf("unexpected checksum length: %d", len(sum))
+	}
+	if sum != checksum([]byte(`[{"ping": 1}]`)) {
+		t.Fatalf("checksum not deterministic")
+	}
+	if sum == checksum([]byte("[{} ]")) {
+		t.Fatalf("checksum collision")
+	}
+}
+
+func Test_driver_verifyChecksums(t *testing.T) {
+	recorded := map[string]string{
+		"1": checksum([]byte(`[{"ping": 1}]`)),
+		"2": checksum([]byte(`[{"ping": 1}]`)), // modified file
+		"3": checksum([]byte(`[{"ping": 1}]`)), // file removed from source
+	}
+
+	d := &driver{cfg: &config{ChecksumVerification: ChecksumVerificationFail}, checksumSource: newChecksumTestSource(t)}
+	err := d.verifyChecksums(recorded, 3)
+	var mismatchErr ChecksumMismatchError
+	if !errors.As(err, &mismatchErr) {
+		t.Fatalf("expected ChecksumMismatchError, got: %v", err)
+	}
+	if len(mismatchErr.Versions) != 1 || mismatchErr.Versions[0] != 2 {
+		t.Fatalf("unexpected mismatching versions: %v", mismatchErr.Versions)
+	}
+
+	d.cfg.ChecksumVerification = ChecksumVerificationWarn
+	d.logger = log.Default()
+	err = d.verifyChecksums(recorded, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	d.cfg.ChecksumVerification = ChecksumVerificationFail
+	err = d.verifyChecksums(recorded, 1) // version 2 was reverted
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	d.cfg.ChecksumVerification = ChecksumVerificationDisabled
+	err = d.verifyChecksums(recorded, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func Test_driver_verifyPendingChecksum(t *testing.T) {
+	d := &driver{
+		cfg:       &config{ChecksumVerification: ChecksumVerificationFail},
+		checksums: map[string]string{"1": checksum([]byte(`[{"ping": 1}]`))},
+		pending:   &pendingMigration{Version: 1, Direction: lightmigrate.Up},
+	}
+
+	if err := d.verifyPendingChecksum(checksum([]byte(`[{"ping": 1}]`))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := d.verifyPendingChecksum(checksum([]byte("[]"))); err == nil {
+		t.Fatalf("expected error, got: %v", err)
+	}
+
+	d.pending = &pendingMigration{Version: 1, Direction: lightmigrate.Down}
+	if err := d.verifyPendingChecksum(checksum([]byte("[]"))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func Test_driver_checksumRecording(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Up", func(mt *mtest.T) {
+		d, err := NewDriver(mt.Client, "test")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // set dirty version
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // migration command
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // migration progress
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // set clean version
+
+		if err = d.SetVersion(1, true); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err = d.RunMigration(bytes.NewReader([]byte(`[{"ping": 1}]`))); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err = d.SetVersion(1, false); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.GetStartedEvent() // set dirty version
+		mt.GetStartedEvent() // migration command
+		mt.GetStartedEvent() // migration progress
+		started := mt.GetStartedEvent()
+		recorded, lookupErr := started.Command.LookupErr("updates", "0", "u", "$set", "checksums.1")
+		if lookupErr != nil || recorded.StringValue() != checksum([]byte(`[{"ping": 1}]`)) {
+			t.Fatalf("checksum not recorded: %v", started.Command)
+		}
+		if d.(*driver).checksums["1"] != checksum([]byte(`[{"ping": 1}]`)) {
+			t.Fatalf("local checksums not updated: %v", d.(*driver).checksums)
+		}
+	})
+
+	mt.Run("Down", func(mt *mtest.T) {
+		d, err := NewDriver(mt.Client, "test")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		d.(*driver).appliedVersion = 1
+		d.(*driver).checksums = map[string]string{"1": checksum([]byte(`[{"ping": 1}]`))}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // set dirty version
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // migration command
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // migration progress
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // set clean version
+
+		if err = d.SetVersion(0, true); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err = d.RunMigration(bytes.NewReader([]byte(`[{"ping": 1}]`))); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err = d.SetVersion(0, false); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.GetStartedEvent() // set dirty version
+		mt.GetStartedEvent() // migration command
+		mt.GetStartedEvent() // migration progress
+		started := mt.GetStartedEvent()
+		if _, lookupErr := started.Command.LookupErr("updates", "0", "u", "$unset", "checksums.1"); lookupErr == nil {
+			t.Fatalf("checksum removed: %v", started.Command)
+		}
+		if _, ok := d.(*driver).checksums["1"]; !ok {
+			t.Fatalf("local checksum removed: %v", d.(*driver).checksums)
+		}
+	})
+
+	mt.Run("VerifyReapplied", func(mt *mtest.T) {
+		d, err := NewDriver(mt.Client, "test", WithChecksumVerification(ChecksumVerificationFail, nil))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		d.(*driver).appliedVersion = 0 // version 1 was reverted
+		d.(*driver).checksums = map[string]string{"1": checksum([]byte(`[{"ping": 1}]`))}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // set dirty version
+
+		if err = d.SetVersion(1, true); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		err = d.RunMigration(bytes.NewReader([]byte(`[{"ping": 2}]`)))
+		var mismatchErr ChecksumMismatchError
+		if !errors.As(err, &mismatchErr) {
+			t.Fatalf("expected ChecksumMismatchError, got: %v", err)
+		}
+	})
+
+	mt.Run("VerifyOnGetVersion", func(mt *mtest.T) {
+		d, err := NewDriver(mt.Client, "test",
+			WithChecksumVerification(ChecksumVerificationFail, newChecksumTestSource(t)))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(1, "test.schema_migrations", mtest.FirstBatch, bson.D{
+			{Key: "version", Value: int64(2)},
+			{Key: "dirty", Value: false},
+			{Key: "checksums", Value: bson.D{
+				{Key: "1", Value: checksum([]byte(`[{"ping": 1}]`))},
+				{Key: "2", Value: checksum([]byte(`[{"ping": 1}]`))},
+			}},
+		}))
+
+		_, _, err = d.GetVersion()
+		var mismatchErr ChecksumMismatchError
+		if !errors.As(err, &mismatchErr) {
+			t.Fatalf("expected ChecksumMismatchError, got: %v", err)
+		}
+	})
+}
@@ -0,0 +1,216 @@
+package mongodb
+
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// migrationCommand is a single command of a migration.
+type migrationCommand struct {
+	// Database is the name of the database the command is executed on. If empty, the migration database is used.
+	Database string
+	// Command is the command document passed to runCommand.
+	Command bson.D
+	// Index is the position of the command within the migration file (starting at 0).
+	Index int
+	// Line and Column describe the position (starting at 1) of the command within the migration file.
+	// Both are 0 if the position is unknown.
+	Line   int
+	Column int
+}
+
+// CommandValidation describes how the driver handles migration commands that do not start with the name of a
+// known database command, e.g. because of a typo.
+type CommandValidation int
+
+const (
+	// CommandValidationWarn logs a warning for each unknown command, the commands are executed nevertheless.
+	// This is the default, as the list of known commands can not cover all commands of all server versions.
+	CommandValidationWarn CommandValidation = iota
+	// CommandValidationFail aborts the migration with a MigrationFormatError before any command is executed.
+	CommandValidationFail
+	// CommandValidationDisabled disables the validation of command names.
+	CommandValidationDisabled
+)
+
+// knownCommands contains the names (and the aliases registered by the server) of all database commands that
+// can be used in migrations, see CommandValidation.
+var knownCommands = map[string]struct{}{
+	// query and write operations
+	"aggregate":     {},
+	"bulkWrite":     {},
+	"count":         {},
+	"delete":        {},
+	"distinct":      {},
+	"find":          {},
+	"findAndModify": {},
+	"findandmodify": {},
+	"getMore":       {},
+	"insert":        {},
+	"killCursors":   {},
+	"mapReduce":     {},
+	"mapreduce":     {},
+	"update":        {},
+	// collection and index administration
+	"applyOps":                       {},
+	"cloneCollectionAsCapped":        {},
+	"collMod":                        {},
+	"compact":                        {},
+	"convertToCapped":                {},
+	"create":                         {},
+	"createIndexes":                  {},
+	"createSearchIndexes":            {},
+	"deleteIndexes":                  {},
+	"drop":                           {},
+	"dropDatabase":                   {},
+	"dropIndexes":                    {},
+	"dropSearchIndex":                {},
+	"listCollections":                {},
+	"listIndexes":                    {},
+	"planCacheClear":                 {},
+	"planCacheClearFilters":          {},
+	"planCacheSetFilter":             {},
+	"reIndex":                        {},
+	"renameCollection":               {},
+	"setFeatureCompatibilityVersion": {},
+	"setIndexCommitQuorum":           {},
+	"updateSearchIndex":              {},
+	"validate":                       {},
+	// user and role management
+	"createRole":               {},
+	"createUser":               {},
+	"dropAllRolesFromDatabase": {},
+	"dropAllUsersFromDatabase": {},
+	"dropRole":                 {},
+	"dropUser":                 {},
+	"grantPrivilegesToRole":    {},
+	"grantRolesToRole":         {},
+	"grantRolesToUser":         {},
+	"invalidateUserCache":      {},
+	"revokePrivilegesFromRole": {},
+	"revokeRolesFromRole":      {},
+	"revokeRolesFromUser":      {},
+	"rolesInfo":                {},
+	"updateRole":               {},
+	"updateUser":               {},
+	"usersInfo":                {},
+	// sharding
+	"addShard":                     {},
+	"addShardToZone":               {},
+	"balancerStart":                {},
+	"balancerStop":                 {},
+	"cleanupOrphaned":              {},
+	"clearJumboFlag":               {},
+	"configureCollectionBalancing": {},
+	"enableSharding":               {},
+	"mergeChunks":                  {},
+	"moveChunk":                    {},
+	"movePrimary":                  {},
+	"moveRange":                    {},
+	"refineCollectionShardKey":     {},
+	"removeShard":                  {},
+	"removeShardFromZone":          {},
+	"reshardCollection":            {},
+	"setAllowMigrations":           {},
+	"shardCollection":              {},
+	"split":                        {},
+	"updateZoneKeyRange":           {},
+	// diagnostics and server administration
+	"buildInfo":           {},
+	"buildinfo":           {},
+	"collStats":           {},
+	"currentOp":           {},
+	"dbStats":             {},
+	"dbstats":             {},
+	"explain":             {},
+	"fsync":               {},
+	"getClusterParameter": {},
+	"getDefaultRWConcern": {},
+	"getParameter":        {},
+	"hello":               {},
+	"isMaster":            {},
+	"ismaster":            {},
+	"killOp":              {},
+	"listDatabases":       {},
+	"ping":                {},
+	"profile":             {},
+	"serverStatus":        {},
+	"setClusterParameter": {},
+	"setDefaultRWConcern": {},
+	"setParameter":        {},
+}
+
+// isKnownCommand checks if the given name is a known database command or one of the configured additional commands.
+// Command names are case-sensitive.
+func (d *driver) isKnownCommand(name string) bool {
+	if _, ok := knownCommands[name]; ok {
+		return true
+	}
+	_, ok := d.cfg.AdditionalCommands[name]
+	return ok
+}
+
+// validateCommands checks that each command starts with the name of a known database command. Depending on the
+// configured CommandValidation, unknown commands abort the migration with a MigrationFormatError or are logged.
+func (d *driver) validateCommands(cmds []migrationCommand) error {
+	if d.cfg.CommandValidation == CommandValidationDisabled {
+		return nil
+	}
+
+	for i, cmd := range cmds {
+		name := commandName(cmd.Command)
+		if d.isKnownCommand(name) {
+			continue
+		}
+
+		err := MigrationFormatError{Index: i, Line: cmd.Line, Column: cmd.Column,
+			Msg: fmt.Sprintf("unknown command %q", name)}
+		if d.pending != nil {
+			err.Version = d.pending.Version
+		}
+		if d.cfg.CommandValidation == CommandValidationFail {
+			return err
+		}
+		d.logMessage("warning: %v", err)
+	}
+	return nil
+}
+
+// transactionalCommands contains the names of all database commands that are allowed within multi-document
+// transactions. All other commands are rejected by the server.
+var transactionalCommands = map[string]struct{}{
+	"aggregate":     {}, // without $out and $merge stages
+	"create":        {},
+	"createIndexes": {},
+	"delete":        {},
+	"distinct":      {},
+	"find":          {},
+	"findAndModify": {},
+	"findandmodify": {},
+	"getMore":       {},
+	"insert":        {},
+	"killCursors":   {},
+	"update":        {},
+}
+
+// transactionRestriction checks if the command is allowed within multi-document transactions.
+// If it is not allowed, the reason is returned, otherwise an empty string.
+func transactionRestriction(cmd bson.D) string {
+	name := commandName(cmd)
+	if _, ok := transactionalCommands[name]; !ok {
+		return "command is not allowed in transactions"
+	}
+
+	if name == "aggregate" {
+		pipeline, _ := cmd.Map()["pipeline"].(bson.A)
+		for _, stage := range pipeline {
+			stageDoc, _ := stage.(bson.D)
+			if stageName := commandName(stageDoc); stageName == "$out" || stageName == "$merge" {
+				return fmt.Sprintf("aggregations with %s stage are not allowed in transactions", stageName)
+			}
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,76 @@
+package mongodb
+
+import (
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestWithCommandValidation(t *testing.T) {
+	d := &driver{cfg: &config{}}
+
+	WithCommandValidation(CommandValidationFail, "customCommand")(d)
+	if d.cfg.CommandValidation != CommandValidationFail {
+		t.Fatalf("failed to set command validation")
+	}
+	if _, ok := d.cfg.AdditionalCommands["customCommand"]; !ok {
+		t.Fatalf("failed to set additional commands")
+	}
+}
+
+func Test_driver_isKnownCommand(t *testing.T) {
+	d := &driver{cfg: &config{AdditionalCommands: map[string]struct{}{"customCommand": {}}}}
+	tests := []struct {
+		name  string
+		known bool
+	}{
+		{name: "createIndexes", known: true},
+		{name: "findAndModify", known: true},
+		{name: "findandmodify", known: true},
+		{name: "setDefaultRWConcern", known: true},
+		{name: "Insert", known: false},
+		{name: "createUser", known: true},
+		{name: "killCursors", known: true},
+		{name: "customCommand", known: true},
+		{name: "udpate", known: false},
+		{name: "", known: false},
+	}
+
+	for _, tt := range tests {
+		if got := d.isKnownCommand(tt.name); got != tt.known {
+			t.Errorf("isKnownCommand(%q) = %t, want %t", tt.name, got, tt.known)
+		}
+	}
+}
+
+func Test_driver_validateCommands(t *testing.T) {
+	cmds, err := parseMigration([]byte("[\n  {\"ping\": 1},\n  {\"udpate\": \"users\"}\n]"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	logger := &testLogger{}
+	d := &driver{cfg: &config{}, logger: logger, pending: &pendingMigration{Version: 3}}
+	if err = d.validateCommands(cmds); err != nil { // warnings by default
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(logger.messages) != 1 {
+		t.Fatalf("unexpected log messages: %v", logger.messages)
+	}
+
+	d.cfg.CommandValidation = CommandValidationFail
+	err = d.validateCommands(cmds)
+	var formatErr MigrationFormatError
+	if !errors.As(err, &formatErr) {
+		t.Fatalf("expected MigrationFormatError, got: %v", err)
+	}
+	if formatErr.Version != 3 || formatErr.Index != 1 || formatErr.Line != 3 || formatErr.Column != 3 {
+		t.Fatalf("unexpected error: %v", formatErr)
+	}
+
+	d.cfg.CommandValidation = CommandValidationDisabled
+	if err = d.validateCommands([]migrationCommand{{Command: bson.D{{Key: "Insert", Value: "users"}}}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
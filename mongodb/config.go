@@ -1,27 +1,129 @@
 package mongodb
 
-import "time"
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
 
 // DefaultMigrationsCollection is the collection to use for migration state by default.
 const DefaultMigrationsCollection = "schema_migrations"
 
+// DefaultHistoryCollection is the collection to use for the migration history by default.
+const DefaultHistoryCollection = "schema_migrations_history"
+
 // DefaultLockingCollection is the collection to use for advisory locking by default.
 const DefaultLockingCollection = "migrate_advisory_lock"
 
 // lockKeyUniqueValue is the unique value to lock on. If multiple clients try to insert the same key, it will fail (locked).
 const lockKeyUniqueValue = 0
 
-// DefaultLockIndexName is the default name of the index which adds unique constraint to the locking_key field.
+// DefaultLockIndexName is the default name of the index which adds unique constraint to the locking_key (and scope) fields.
 const DefaultLockIndexName = "lock_unique_key"
 
-// contextWaitTimeout describes how long to wait for the request to mongo to block/wait for.
-const contextWaitTimeout = 5 * time.Second
+// DefaultLockRetryInterval is the initial delay between two lock acquisition attempts by default.
+const DefaultLockRetryInterval = 100 * time.Millisecond
+
+// DefaultLockMaxRetryInterval is the maximum delay between two lock acquisition attempts by default.
+const DefaultLockMaxRetryInterval = 5 * time.Second
+
+// DefaultRetryMaxAttempts is the maximum number of attempts of a command or transaction by default, if retries are enabled.
+const DefaultRetryMaxAttempts = 3
+
+// DefaultRetryInterval is the initial delay between two attempts by default.
+const DefaultRetryInterval = 100 * time.Millisecond
+
+// DefaultRetryMaxInterval is the maximum delay between two attempts by default.
+const DefaultRetryMaxInterval = 5 * time.Second
+
+// DefaultTransactionTimeout is the maximum duration of a transaction, including all commit retries, by default.
+const DefaultTransactionTimeout = 2 * time.Minute
+
+// DefaultLockTimeout describes how long a single locking request to mongo is allowed to block/wait by default.
+const DefaultLockTimeout = 5 * time.Second
 
 type config struct {
 	DatabaseName         string
+	StateDatabaseName    string
 	MigrationsCollection string
 	TransactionMode      bool
+	Transaction          TransactionConfig
+	ResumeMode           bool
 	Locking              LockingConfig
+	History              HistoryConfig
+	Timeouts             TimeoutConfig
+	Retry                RetryConfig
+	ChecksumVerification ChecksumVerification
+	CommandValidation    CommandValidation
+	AdditionalCommands   map[string]struct{}
+}
+
+// HistoryConfig can be used to configure the migration history of the MongoDB migration driver.
+type HistoryConfig struct {
+	// CollectionName is the collection name where one document per applied or reverted migration will be stored.
+	// Defaults to DefaultHistoryCollection, prefixed with the migrated database name if WithStateDatabase is used.
+	CollectionName string
+	// Enabled flag can be used to enable or disable the migration history, by default it is disabled.
+	Enabled bool
+}
+
+// TransactionConfig can be used to configure the transactions of the MongoDB migration driver (see WithTransactions).
+// Transactions are run with commit-with-retry semantics: transient transaction errors and unknown commit results
+// are retried until the transaction succeeds or the timeout is reached.
+type TransactionConfig struct {
+	// Timeout bounds the duration of a transaction, including all retries (see WithRetry).
+	// Independent of the timeout, the MongoDB client stops retrying transient transaction errors and unknown commit
+	// results 120 seconds after the transaction was started. With a longer timeout, only the retries configured by
+	// WithRetry continue afterwards. Defaults to DefaultTransactionTimeout.
+	Timeout time.Duration
+	// ReadConcern is the read concern of the transaction. Defaults to the read concern of the client.
+	ReadConcern *readconcern.ReadConcern
+	// WriteConcern is the write concern of the transaction. Defaults to the write concern of the client.
+	WriteConcern *writeconcern.WriteConcern
+	// ReadPreference is the read preference of the transaction, it must be primary. Defaults to the read preference
+	// of the client.
+	ReadPreference *readpref.ReadPref
+	// IncludeVersion flag can be used to store the new migration version within the same transaction as the
+	// migration commands, so that the data and the version can not diverge. The migrations collection must be
+	// located on the same replica set as the migrated data.
+	IncludeVersion bool
+	// NonTransactionalPolicy decides how commands that are not allowed within transactions are handled.
+	// Defaults to NonTransactionalFail.
+	NonTransactionalPolicy NonTransactionalPolicy
+}
+
+// RetryConfig can be used to configure retries of migration commands that failed with a transient error,
+// e.g. during a replica set election. Without transactions, each command is retried individually, otherwise
+// the whole transaction is retried.
+type RetryConfig struct {
+	// Enabled flag can be used to enable or disable retries, by default it is disabled.
+	Enabled bool
+	// MaxAttempts is the maximum number of attempts, including the first one. Defaults to DefaultRetryMaxAttempts.
+	MaxAttempts int
+	// RetryInterval is the initial delay between two attempts, it is doubled after each attempt.
+	// Defaults to DefaultRetryInterval.
+	RetryInterval time.Duration
+	// MaxRetryInterval is the maximum delay between two attempts. Defaults to DefaultRetryMaxInterval.
+	MaxRetryInterval time.Duration
+	// Retryable decides if an error is transient and the command should be retried. Defaults to IsTransientError.
+	Retryable func(err error) bool
+}
+
+// TimeoutConfig can be used to bound the duration of the MongoDB requests issued by the driver.
+// A timeout of 0 means that requests are only bounded by the base context (see WithContext).
+type TimeoutConfig struct {
+	// Lock is the timeout for a single lock request (acquire, renew or release) and for recording a history entry.
+	// Releasing the lock and recording the history are not aborted by cancelling the base context.
+	// Defaults to DefaultLockTimeout.
+	Lock time.Duration
+	// Version is the timeout for reading, writing or resetting the migration version. Defaults to 0.
+	Version time.Duration
+	// Migration is the timeout for running all commands of a single migration. Defaults to 0.
+	Migration time.Duration
+	// Index is the timeout for preparing the indexes of the locking collection. Defaults to 0.
+	Index time.Duration
 }
 
 // LockingConfig can be used to configure the locking behaviour of the MongoDB migration driver.
@@ -31,6 +133,30 @@ type LockingConfig struct {
 	// IndexName is the name of the unique index that is required for the locking process.
 	// Defaults to DefaultLockIndexName.
 	IndexName string
+	// Scope is the name of the lock. Migration processes with different scopes do not block each other.
+	// Defaults to the name of the migrations collection.
+	Scope string
+	// LegacyCompatibility flag can be used to exclude migration processes of driver versions without lock scopes,
+	// e.g. during a rolling upgrade. The unique index of these versions (on the locking_key only) is used and their
+	// lock objects are treated as lock objects of this scope. As this index allows only one lock object per
+	// locking collection, different scopes block each other. By default, it is disabled.
+	LegacyCompatibility bool
 	// Enabled flag can be used to enable or disable locking, by default it is disabled.
 	Enabled bool
+	// LeaseDuration specifies how long an acquired lock stays valid. Once the lease has expired, another
+	// migration process is allowed to take over the lock. Defaults to 0, which means that locks never expire.
+	LeaseDuration time.Duration
+	// HeartbeatInterval specifies how often the lease of an acquired lock is renewed in the background.
+	// Only used if LeaseDuration is set, it must be shorter than the LeaseDuration.
+	// Defaults to a third of the LeaseDuration.
+	HeartbeatInterval time.Duration
+	// WaitTimeout specifies how long Lock waits for a lock that is held by another process.
+	// Defaults to 0, which means that Lock fails immediately with ErrDatabaseLocked.
+	WaitTimeout time.Duration
+	// RetryInterval is the initial delay between two lock acquisition attempts. The delay is doubled
+	// after each failed attempt. Defaults to DefaultLockRetryInterval.
+	RetryInterval time.Duration
+	// MaxRetryInterval is the upper bound for the delay between two lock acquisition attempts.
+	// Defaults to DefaultLockMaxRetryInterval.
+	MaxRetryInterval time.Duration
 }
@@ -0,0 +1,22 @@
+package mongodb
+
+// planMigration logs the (already validated) commands of the migration in execution order, without executing them.
+func (d *driver) planMigration(cmds []migrationCommand) error {
+	version := uint64(0)
+	direction := "up"
+	if d.pending != nil {
+		version = d.pending.Version
+		direction = string(d.pending.Direction)
+	}
+
+	d.logMessage("dry run: migration %d (%s) with %d command(s)", version, direction, len(cmds))
+	for i, cmd := range cmds {
+		d.logMessage("dry run: command %d/%d on %s: %s", i+1, len(cmds), d.commandDatabase(cmd).Name(),
+			formatCommand(cmd.Command))
+	}
+	if d.goMigration() != nil {
+		d.logMessage("dry run: go migration on %s", d.targetDb.Name())
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package mongodb
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestWithDryRun(t *testing.T) {
+	d := &driver{}
+
+	WithDryRun(true)(d)
+	if !d.dryRun {
+		t.Fatalf("failed to set dry run flag")
+	}
+}
+
+func Test_driver_DryRun(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Success", func(mt *mtest.T) {
+		logger := &testLogger{}
+		d, err := NewDriver(mt.Client, "test", WithDryRun(true), WithLogger(logger))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if err = d.SetVersion(1, true); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		err = d.RunMigration(bytes.NewReader([]byte(`[{"createUser": "user", "pwd": "secret", "roles": []},
+			{"update": "users", "updates": [{"q": {}, "u": {"$set": {"status": "active"}}, "multi": true}]}]`)))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err = d.SetVersion(1, false); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if started := mt.GetStartedEvent(); started != nil {
+			t.Fatalf("unexpected command: %v", started)
+		}
+
+		if len(logger.messages) != 3 {
+			t.Fatalf("unexpected log messages: %v", logger.messages)
+		}
+		if !strings.Contains(logger.messages[0], "migration 1 (up) with 2 command(s)") {
+			t.Fatalf("unexpected log message: %s", logger.messages[0])
+		}
+		if !strings.Contains(logger.messages[1], "createUser") || strings.Contains(logger.messages[1], "secret") {
+			t.Fatalf("unexpected log message: %s", logger.messages[1])
+		}
+		if !strings.Contains(logger.messages[2], "on test") {
+			t.Fatalf("missing target database: %s", logger.messages[2])
+		}
+	})
+
+	mt.Run("UnknownCommand", func(mt *mtest.T) {
+		d, err := NewDriver(mt.Client, "test", WithDryRun(true), WithCommandValidation(CommandValidationFail))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		err = d.RunMigration(bytes.NewReader([]byte(`[{"ping": 1}, {"udpate": "users"}]`)))
+		if err == nil {
+			t.Fatalf("expected error, got: %v", err)
+		}
+
+		if started := mt.GetStartedEvent(); started != nil {
+			t.Fatalf("unexpected command: %v", started)
+		}
+	})
+}
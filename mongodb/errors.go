@@ -1,6 +1,11 @@
 package mongodb
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
 
 var (
 	// ErrNoDatabaseName signals a missing database name.
@@ -9,4 +14,57 @@ var (
 	ErrNoDatabaseClient = fmt.Errorf("no database client")
 	// ErrDatabaseLocked signals that the database is already locked by another migration process.
 	ErrDatabaseLocked = fmt.Errorf("database is locked")
+	// ErrLockLost signals that the database lock has been taken over by another migration process.
+	ErrLockLost = fmt.Errorf("database lock was lost")
+	// ErrLockingDisabled signals that the lock can not be inspected or released because locking is disabled.
+	ErrLockingDisabled = fmt.Errorf("locking is disabled")
+	// ErrInvalidHeartbeatInterval signals a lock heartbeat interval that is not shorter than the lock lease duration.
+	ErrInvalidHeartbeatInterval = fmt.Errorf("lock heartbeat interval must be shorter than the lease duration")
 )
+
+// CommandWriteError signals write errors or a write concern error that were reported inside
+// an otherwise successful (ok: 1) command reply, e.g. of an update, insert or delete command.
+type CommandWriteError struct {
+	// Command is the name of the failed command.
+	Command string
+	// WriteErrors contains all failed statements of the command.
+	WriteErrors []mongo.WriteError
+	// WriteConcernError is the write concern error of the command, or nil if there was none.
+	WriteConcernError *mongo.WriteConcernError
+	// Labels contains the error labels of the command reply, e.g. RetryableWriteError.
+	Labels []string
+}
+
+// Error implements error interface.
+func (e CommandWriteError) Error() string {
+	var details []string
+	for _, writeErr := range e.WriteErrors {
+		details = append(details, fmt.Sprintf("write error at index %d: (%d) %s",
+			writeErr.Index, writeErr.Code, writeErr.Message))
+	}
+	if e.WriteConcernError != nil {
+		details = append(details, fmt.Sprintf("write concern error: (%s) %s",
+			e.WriteConcernError.Name, e.WriteConcernError.Message))
+	}
+	return fmt.Sprintf("command %s reported errors: %s", e.Command, strings.Join(details, "; "))
+}
+
+// HasErrorLabel returns true if the command reply contains the given error label.
+func (e CommandWriteError) HasErrorLabel(label string) bool {
+	for _, l := range e.Labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+// HasErrorCode returns true if any of the write errors or the write concern error has the given code.
+func (e CommandWriteError) HasErrorCode(code int) bool {
+	for _, writeErr := range e.WriteErrors {
+		if writeErr.Code == code {
+			return true
+		}
+	}
+	return e.WriteConcernError != nil && e.WriteConcernError.Code == code
+}
@@ -0,0 +1,195 @@
+package mongodb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// MigrationFormatError signals a malformed migration file.
+type MigrationFormatError struct {
+	// Version is the version of the migration, 0 if unknown.
+	Version uint64
+	// Index is the position of the invalid command within the migration, -1 if the whole file is invalid.
+	Index int
+	// Line and Column describe the position (starting at 1) of the problem within the migration file.
+	// Both are 0 if the position is unknown.
+	Line   int
+	Column int
+	// Msg describes the problem.
+	Msg string
+	// Err is the underlying error, if any.
+	Err error
+}
+
+// Error implements error interface.
+func (e MigrationFormatError) Error() string {
+	msg := "invalid migration"
+	if e.Version != 0 {
+		msg += fmt.Sprintf(" %d", e.Version)
+	}
+	if e.Index >= 0 {
+		msg += fmt.Sprintf(", command at index %d", e.Index)
+	}
+	if e.Line > 0 {
+		msg += fmt.Sprintf(" (line %d, column %d)", e.Line, e.Column)
+	}
+	msg += ": " + e.Msg
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap returns the underlying error.
+func (e MigrationFormatError) Unwrap() error {
+	return e.Err
+}
+
+// commandEnvelopeDatabaseKey is the key of the target database within a command envelope.
+const commandEnvelopeDatabaseKey = "$db"
+
+// commandEnvelopeCommandKey is the key of the command document within a command envelope.
+const commandEnvelopeCommandKey = "command"
+
+// decodeMigration parses and validates a migration file. The format is detected by its content: migrations that start
+// with a statement on the db object are parsed as mongo shell scripts (see parseScript), migrations that start with
+// a JSON array or object as JSON and all others as YAML (see parseYAMLMigration).
+// The database name is used for script commands that require a fully qualified namespace.
+func decodeMigration(migr []byte, database string) ([]migrationCommand, error) {
+	var cmds []migrationCommand
+	var err error
+	if isScript(migr) {
+		cmds, err = parseScript(migr, database)
+	} else if trimmed := bytes.TrimSpace(migr); len(trimmed) != 0 && (trimmed[0] == '[' || trimmed[0] == '{') {
+		cmds, err = parseMigration(migr)
+	} else {
+		cmds, err = parseYAMLMigration(migr)
+	}
+
+	for i := range cmds {
+		cmds[i].Index = i
+	}
+	return cmds, err
+}
+
+// parseMigration parses and validates a JSON migration file. The top level element must be an array of
+// non-empty command documents. The command names are validated by the driver, see CommandValidation.
+// A command can optionally be wrapped in an envelope that specifies the target database:
+// {"$db": "admin", "command": {...}}
+func parseMigration(migr []byte) ([]migrationCommand, error) {
+	dec := json.NewDecoder(bytes.NewReader(migr))
+	dec.UseNumber()
+
+	token, err := dec.Token()
+	if err != nil {
+		return nil, newFormatError(migr, -1, jsonErrorOffset(err, dec), "malformed json", err)
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return nil, newFormatError(migr, -1, 0, "top level element must be an array of commands", nil)
+	}
+
+	var cmds []migrationCommand
+	for index := 0; dec.More(); index++ {
+		offset := skipSeparators(migr, dec.InputOffset())
+		var element json.RawMessage
+		if err := dec.Decode(&element); err != nil {
+			return nil, newFormatError(migr, index, jsonErrorOffset(err, dec), "malformed json", err)
+		}
+
+		trimmed := bytes.TrimSpace(element)
+		if len(trimmed) == 0 || trimmed[0] != '{' {
+			return nil, newFormatError(migr, index, offset, "command must be a document", nil)
+		}
+
+		var doc bson.D
+		if err := bson.UnmarshalExtJSON(trimmed, true, &doc); err != nil {
+			return nil, newFormatError(migr, index, offset, "invalid extended json", err)
+		}
+		cmd, msg := unwrapCommand(doc)
+		if msg != "" {
+			return nil, newFormatError(migr, index, offset, msg, nil)
+		}
+		cmd.Line, cmd.Column = position(migr, offset)
+
+		cmds = append(cmds, cmd)
+	}
+
+	if _, err := dec.Token(); err != nil { // closing bracket
+		return nil, newFormatError(migr, -1, jsonErrorOffset(err, dec), "malformed json", err)
+	}
+
+	return cmds, nil
+}
+
+// unwrapCommand validates the command document and extracts the target database of command envelopes.
+// If the document is invalid, a message describing the problem is returned.
+func unwrapCommand(doc bson.D) (migrationCommand, string) {
+	var cmd migrationCommand
+	if _, isEnvelope := doc.Map()[commandEnvelopeDatabaseKey]; isEnvelope {
+		for _, elem := range doc {
+			switch elem.Key {
+			case commandEnvelopeDatabaseKey:
+				database, ok := elem.Value.(string)
+				if !ok || database == "" {
+					return cmd, "envelope database must be a non-empty string"
+				}
+				cmd.Database = database
+			case commandEnvelopeCommandKey:
+				command, ok := elem.Value.(bson.D)
+				if !ok {
+					return cmd, "envelope command must be a document"
+				}
+				cmd.Command = command
+			default:
+				return cmd, fmt.Sprintf("unexpected envelope field %q", elem.Key)
+			}
+		}
+	} else {
+		cmd.Command = doc
+	}
+
+	if len(cmd.Command) == 0 {
+		return cmd, "command document is empty"
+	}
+
+	return cmd, ""
+}
+
+// newFormatError creates a MigrationFormatError for the given byte offset within the migration file.
+func newFormatError(migr []byte, index int, offset int64, msg string, err error) MigrationFormatError {
+	line, column := position(migr, offset)
+	return MigrationFormatError{Index: index, Line: line, Column: column, Msg: msg, Err: err}
+}
+
+// jsonErrorOffset returns the byte offset of a json syntax error, or the current decoder offset.
+func jsonErrorOffset(err error, dec *json.Decoder) int64 {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return syntaxErr.Offset
+	}
+	return dec.InputOffset()
+}
+
+// skipSeparators returns the offset of the next value, skipping whitespace and commas.
+func skipSeparators(migr []byte, offset int64) int64 {
+	for offset < int64(len(migr)) && bytes.IndexByte([]byte(" \t\r\n,"), migr[offset]) >= 0 {
+		offset++
+	}
+	return offset
+}
+
+// position converts a byte offset to a line and column (both starting at 1).
+func position(migr []byte, offset int64) (line, column int) {
+	if offset > int64(len(migr)) {
+		offset = int64(len(migr))
+	}
+
+	before := migr[:offset]
+	line = bytes.Count(before, []byte("\n")) + 1
+	column = int(offset) - (bytes.LastIndexByte(before, '\n') + 1) + 1
+	return line, column
+}
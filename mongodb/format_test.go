@@ -0,0 +1,159 @@
+package mongodb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/h44z/lightmigrate"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func Test_parseMigration(t *testing.T) {
+	cmds, err := parseMigration([]byte(`[
+  {"insert": "users", "documents": [{"created": {"$date": {"$numberLong": "1640995200000"}}}]},
+  {"ping": 1}
+]`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cmds) != 2 || commandName(cmds[0].Command) != "insert" || commandName(cmds[1].Command) != "ping" {
+		t.Fatalf("unexpected commands: %v", cmds)
+	}
+
+	docs := cmds[0].Command[1].Value.(primitive.A)
+	if _, ok := docs[0].(primitive.D)[0].Value.(primitive.DateTime); !ok {
+		t.Fatalf("extended json types not preserved: %v", docs)
+	}
+}
+
+func Test_parseMigration_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		migr   string
+		index  int
+		line   int
+		column int
+		msg    string
+	}{
+		{name: "NoArray", migr: `{"ping": 1}`, index: -1, msg: "top level element must be an array"},
+		{name: "Malformed", migr: "[\n  {\"ping\": 1},\n  {\"ping\" 1}\n]", index: 1, line: 3, msg: "malformed json"},
+		{name: "NoDocument", migr: "[\n  {\"ping\": 1},\n  \"ping\"\n]", index: 1, line: 3, column: 3, msg: "command must be a document"},
+		{name: "Empty", migr: "[{\"ping\": 1}, {}]", index: 1, line: 1, column: 15, msg: "command document is empty"},
+		{name: "ExtendedJson", migr: `[{"insert": "users", "documents": [{"n": {"$numberLong": "x"}}]}]`, index: 0, line: 1, column: 2, msg: "invalid extended json"},
+		{name: "Unterminated", migr: `[{"ping": 1}`, index: 1, msg: "malformed json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseMigration([]byte(tt.migr))
+			var formatErr MigrationFormatError
+			if !errors.As(err, &formatErr) {
+				t.Fatalf("expected MigrationFormatError, got: %v", err)
+			}
+			if formatErr.Index != tt.index {
+				t.Errorf("unexpected index: %d", formatErr.Index)
+			}
+			if tt.line != 0 && formatErr.Line != tt.line {
+				t.Errorf("unexpected line: %d", formatErr.Line)
+			}
+			if tt.column != 0 && formatErr.Column != tt.column {
+				t.Errorf("unexpected column: %d", formatErr.Column)
+			}
+			if !strings.Contains(formatErr.Error(), tt.msg) {
+				t.Errorf("unexpected message: %s", formatErr.Error())
+			}
+		})
+	}
+}
+
+func TestMigrationFormatError_Unwrap(t *testing.T) {
+	_, err := parseMigration([]byte(`[{"ping" 1}]`))
+
+	var syntaxErr *json.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Fatalf("expected wrapped json.SyntaxError, got: %v", err)
+	}
+}
+
+func TestMigrationFormatError_Error(t *testing.T) {
+	err := MigrationFormatError{Version: 3, Index: 1, Line: 4, Column: 2, Msg: "command document is empty"}
+	expected := "invalid migration 3, command at index 1 (line 4, column 2): command document is empty"
+	if err.Error() != expected {
+		t.Fatalf("unexpected error message: %s", err.Error())
+	}
+}
+
+func Test_parseMigration_Examples(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("..", "examples", "*", "*.json"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, file := range files {
+		migr, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := parseMigration(migr); err != nil {
+			t.Errorf("failed to parse %s: %v", file, err)
+		}
+	}
+}
+
+func Test_driver_RunMigration_FormatError(t *testing.T) {
+	d, err := NewDriver(&mongo.Client{}, "db")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	d.(*driver).pending = &pendingMigration{Version: 3, Direction: lightmigrate.Up}
+
+	err = d.RunMigration(bytes.NewReader([]byte(`[{}]`)))
+	var formatErr MigrationFormatError
+	if !errors.As(err, &formatErr) {
+		t.Fatalf("expected MigrationFormatError, got: %v", err)
+	}
+	if formatErr.Version != 3 {
+		t.Fatalf("unexpected version: %d", formatErr.Version)
+	}
+}
+
+func Test_parseMigration_Envelope(t *testing.T) {
+	cmds, err := parseMigration([]byte(`[
+  {"$db": "admin", "command": {"renameCollection": "app.users", "to": "app.accounts"}},
+  {"ping": 1}
+]`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cmds[0].Database != "admin" || commandName(cmds[0].Command) != "renameCollection" {
+		t.Fatalf("unexpected envelope command: %+v", cmds[0])
+	}
+	if cmds[1].Database != "" {
+		t.Fatalf("unexpected database: %s", cmds[1].Database)
+	}
+	if cmds[1].Line != 3 || cmds[1].Column != 3 {
+		t.Fatalf("unexpected position: line %d, column %d", cmds[1].Line, cmds[1].Column)
+	}
+
+	invalid := map[string]string{
+		"NoDatabaseName": `[{"$db": "", "command": {"ping": 1}}]`,
+		"NoCommand":      `[{"$db": "admin"}]`,
+		"NoDocument":     `[{"$db": "admin", "command": "ping"}]`,
+		"UnknownField":   `[{"$db": "admin", "command": {"ping": 1}, "other": 1}]`,
+	}
+	for name, migr := range invalid {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseMigration([]byte(migr))
+			var formatErr MigrationFormatError
+			if !errors.As(err, &formatErr) || formatErr.Index != 0 {
+				t.Fatalf("expected MigrationFormatError, got: %v", err)
+			}
+		})
+	}
+}
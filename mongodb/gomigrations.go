@@ -0,0 +1,59 @@
+package mongodb
+
+import (
+	"context"
+
+	"github.com/h44z/lightmigrate"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MigrationFunc is a migration step implemented in Go. The database is the one passed to NewDriver.
+// If transactions are enabled, ctx is a mongo.SessionContext and all requests using it are part of the transaction.
+type MigrationFunc func(ctx context.Context, db *mongo.Database) error
+
+// GoMigration contains the Go functions of a single migration version. Each function is optional.
+type GoMigration struct {
+	// Up is run when the migration is applied, after the commands of the migration file.
+	Up MigrationFunc
+	// Down is run when the migration is reverted, after the commands of the migration file.
+	Down MigrationFunc
+}
+
+// MigrationRegistry maps migration versions to Go migrations.
+// A migration file for the version must still exist in the migration source, it may contain an empty
+// command list (or no content at all) if the migration is implemented in Go only.
+type MigrationRegistry map[uint64]GoMigration
+
+// goMigration returns the registered Go function for the pending migration, or nil if there is none.
+func (d *driver) goMigration() MigrationFunc {
+	if d.pending == nil {
+		return nil
+	}
+
+	migration, ok := d.goMigrations[d.pending.Version]
+	if !ok {
+		return nil
+	}
+	if d.pending.Direction == lightmigrate.Down {
+		return migration.Down
+	}
+	return migration.Up
+}
+
+// executeMigration runs the commands of the migration file followed by the Go function (if any).
+func (d *driver) executeMigration(ctx context.Context, cmds []migrationCommand, fn MigrationFunc) error {
+	if err := d.executeCommands(ctx, cmds); err != nil {
+		return err
+	}
+	if fn == nil {
+		return nil
+	}
+
+	d.logVerbose("executing go migration")
+	if err := fn(ctx, d.targetDb); err != nil {
+		d.logVerbose("go migration failed: %v", err)
+		return &lightmigrate.DriverError{OrigErr: err, Msg: "failed to execute go migration"}
+	}
+	d.logVerbose("go migration finished")
+	return nil
+}
@@ -0,0 +1,152 @@
+package mongodb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/h44z/lightmigrate"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestWithGoMigrations(t *testing.T) {
+	d := &driver{}
+
+	WithGoMigrations(MigrationRegistry{1: {}})(d)
+	if _, ok := d.goMigrations[1]; !ok {
+		t.Fatalf("failed to set go migrations")
+	}
+}
+
+func Test_driver_goMigration(t *testing.T) {
+	up := func(ctx context.Context, db *mongo.Database) error { return nil }
+	d := &driver{goMigrations: MigrationRegistry{2: {Up: up}}}
+
+	if d.goMigration() != nil {
+		t.Fatalf("unexpected go migration without pending migration")
+	}
+
+	d.pending = &pendingMigration{Version: 2, Direction: lightmigrate.Up}
+	if d.goMigration() == nil {
+		t.Fatalf("expected up migration")
+	}
+
+	d.pending = &pendingMigration{Version: 2, Direction: lightmigrate.Down}
+	if d.goMigration() != nil {
+		t.Fatalf("unexpected down migration")
+	}
+
+	d.pending = &pendingMigration{Version: 3, Direction: lightmigrate.Up}
+	if d.goMigration() != nil {
+		t.Fatalf("unexpected migration for unregistered version")
+	}
+}
+
+func Test_driver_RunMigration_GoMigration(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Alongside", func(mt *mtest.T) {
+		called := false
+		registry := MigrationRegistry{1: {Up: func(ctx context.Context, db *mongo.Database) error {
+			called = true
+			return db.RunCommand(ctx, bson.D{{Key: "ping", Value: 1}}).Err()
+		}}}
+		d, err := NewDriver(mt.Client, "test", WithGoMigrations(registry))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		d.(*driver).pending = &pendingMigration{Version: 1, Direction: lightmigrate.Up}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		err = d.RunMigration(bytes.NewReader([]byte(`[{"create": "users"}]`)))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !called {
+			t.Fatalf("go migration was not called")
+		}
+		if started := mt.GetStartedEvent(); started.CommandName != "create" {
+			t.Fatalf("expected file commands first, got: %s", started.CommandName)
+		}
+		if started := mt.GetStartedEvent(); started.CommandName != "ping" || started.DatabaseName != "test" {
+			t.Fatalf("unexpected go migration command: %s on %s", started.CommandName, started.DatabaseName)
+		}
+	})
+
+	mt.Run("InPlace", func(mt *mtest.T) {
+		called := false
+		registry := MigrationRegistry{1: {Up: func(ctx context.Context, db *mongo.Database) error {
+			called = true
+			return nil
+		}}}
+		d, err := NewDriver(mt.Client, "test", WithGoMigrations(registry))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		d.(*driver).pending = &pendingMigration{Version: 1, Direction: lightmigrate.Up}
+
+		err = d.RunMigration(bytes.NewReader([]byte("\n")))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !called {
+			t.Fatalf("go migration was not called")
+		}
+		if started := mt.GetStartedEvent(); started != nil {
+			t.Fatalf("unexpected command: %v", started)
+		}
+	})
+
+	mt.Run("Error", func(mt *mtest.T) {
+		migrationErr := errors.New("backfill failed")
+		registry := MigrationRegistry{1: {Up: func(ctx context.Context, db *mongo.Database) error {
+			return migrationErr
+		}}}
+		d, err := NewDriver(mt.Client, "test", WithGoMigrations(registry))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		d.(*driver).pending = &pendingMigration{Version: 1, Direction: lightmigrate.Up}
+
+		err = d.RunMigration(bytes.NewReader([]byte(`[]`)))
+		if !errors.Is(err, migrationErr) {
+			t.Fatalf("expected go migration error, got: %v", err)
+		}
+	})
+}
+
+func Test_driver_executeCommandsWithTransaction_GoMigration(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Success", func(mt *mtest.T) {
+		d, err := NewDriver(mt.Client, "test")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		inSession := false
+		fn := func(ctx context.Context, db *mongo.Database) error {
+			inSession = mongo.SessionFromContext(ctx) != nil
+			return nil
+		}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // command
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // commit
+
+		err = d.(*driver).executeCommandsWithTransaction(context.Background(),
+			[]migrationCommand{{Command: bson.D{{Key: "ping", Value: 1}}}}, fn, true)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !inSession {
+			t.Fatalf("go migration was not run within the transaction session")
+		}
+	})
+}
@@ -0,0 +1,105 @@
+package mongodb
+
+import (
+	"fmt"
+	"os"
+	"runtime/debug"
+	"time"
+
+	"github.com/h44z/lightmigrate"
+)
+
+// modulePath is the Go module path of this driver, used to look up the driver version in the build info.
+const modulePath = "github.com/h44z/lightmigrate-mongodb"
+
+// pendingMigration describes the migration that is currently applied or reverted.
+type pendingMigration struct {
+	Version      uint64
+	Direction    lightmigrate.Direction
+	Target       uint64 // version that is stored once the migration succeeded
+	Checksum     string // checksum of the migration contents, set when the migration is run
+	VersionSaved bool   // set if the target version was already stored within the migration transaction
+}
+
+type historyEntry struct {
+	Version       int64                  `bson:"version"`
+	Direction     lightmigrate.Direction `bson:"direction"`
+	StartedAt     time.Time              `bson:"started_at"`
+	FinishedAt    time.Time              `bson:"finished_at"`
+	DurationMs    int64                  `bson:"duration_ms"`
+	Hostname      string                 `bson:"hostname"`
+	Pid           int                    `bson:"pid"`
+	DriverVersion string                 `bson:"driver_version"`
+	Checksum      string                 `bson:"checksum"`
+	Error         string                 `bson:"error,omitempty"`
+}
+
+// newPendingMigration derives the version and direction of the migration that leads from the applied version
+// to the target version. When reverting a migration, lightmigrate always stores the reverted version minus one,
+// so the migration version is derived from the target version. The applied version can not be used, as it differs
+// from the reverted version if the migration versions are not contiguous (e.g. 4 after reverting 5 of 1, 3 and 5).
+func newPendingMigration(appliedVersion, targetVersion uint64) *pendingMigration {
+	if targetVersion < appliedVersion {
+		return &pendingMigration{Version: targetVersion + 1, Direction: lightmigrate.Down, Target: targetVersion}
+	}
+	return &pendingMigration{Version: targetVersion, Direction: lightmigrate.Up, Target: targetVersion}
+}
+
+// recordHistory appends a history entry for the current migration to the history collection.
+// Failures are only logged, as the migration itself has already been executed.
+func (d *driver) recordHistory(checksum string, startedAt time.Time, migrationErr error) {
+	if !d.cfg.History.Enabled {
+		return
+	}
+
+	pid := os.Getpid()
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = fmt.Sprintf("unknown-host-%d", pid) // use pid as fallback
+	}
+
+	finishedAt := time.Now()
+	entry := historyEntry{
+		StartedAt:     startedAt,
+		FinishedAt:    finishedAt,
+		DurationMs:    finishedAt.Sub(startedAt).Milliseconds(),
+		Hostname:      hostname,
+		Pid:           pid,
+		DriverVersion: driverVersion(),
+		Checksum:      checksum,
+	}
+	if d.pending != nil {
+		entry.Version = int64(d.pending.Version)
+		entry.Direction = d.pending.Direction
+	}
+	if migrationErr != nil {
+		entry.Error = migrationErr.Error()
+	}
+
+	ctx, cancelFunc := d.cleanupContext() // the migration might have been aborted by cancelling the base context
+	defer cancelFunc()
+
+	_, err = d.migDb.Collection(d.cfg.History.CollectionName).InsertOne(ctx, entry)
+	if err != nil {
+		d.logMessage("failed to record migration history for version %d: %v", entry.Version, err)
+	}
+}
+
+// driverVersion returns the module version of this driver as recorded in the build info of the binary.
+func driverVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+
+	if info.Main.Path == modulePath {
+		return info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			return dep.Version
+		}
+	}
+
+	return "unknown"
+}
@@ -0,0 +1,157 @@
+package mongodb
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/h44z/lightmigrate"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestWithHistory(t *testing.T) {
+	d, err := NewDriver(&mongo.Client{}, "db", WithHistory(HistoryConfig{Enabled: true}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !d.(*driver).cfg.History.Enabled {
+		t.Fatalf("failed to enable history")
+	}
+	if d.(*driver).cfg.History.CollectionName != DefaultHistoryCollection {
+		t.Fatalf("unexpected collection name: %s", d.(*driver).cfg.History.CollectionName)
+	}
+
+	d, err = NewDriver(&mongo.Client{}, "db", WithStateDatabase("ops"), WithHistory(HistoryConfig{Enabled: true}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d.(*driver).cfg.History.CollectionName != "db_"+DefaultHistoryCollection {
+		t.Fatalf("unexpected collection name: %s", d.(*driver).cfg.History.CollectionName)
+	}
+}
+
+func Test_newPendingMigration(t *testing.T) {
+	up := newPendingMigration(2, 3)
+	if up.Version != 3 || up.Direction != lightmigrate.Up {
+		t.Fatalf("unexpected pending migration: %+v", up)
+	}
+
+	down := newPendingMigration(3, 2)
+	if down.Version != 3 || down.Direction != lightmigrate.Down {
+		t.Fatalf("unexpected pending migration: %+v", down)
+	}
+
+	// versions 1, 3 and 5: after reverting 5, version 4 is stored and 3 is reverted next
+	down = newPendingMigration(4, 2)
+	if down.Version != 3 || down.Direction != lightmigrate.Down || down.Target != 2 {
+		t.Fatalf("unexpected pending migration: %+v", down)
+	}
+}
+
+func Test_driver_recordHistory(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Success", func(mt *mtest.T) {
+		d, err := NewDriver(mt.Client, "test", WithHistory(HistoryConfig{Enabled: true}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // set dirty version
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // migration command
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // migration progress
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // history entry
+
+		err = d.SetVersion(1, true)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		err = d.RunMigration(bytes.NewReader([]byte(`[{"ping": 1}]`)))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.GetStartedEvent() // set dirty version
+		mt.GetStartedEvent() // migration command
+		mt.GetStartedEvent() // migration progress
+		started := mt.GetStartedEvent()
+		if started == nil || started.CommandName != "insert" {
+			t.Fatalf("expected history insert, got: %v", started)
+		}
+		entry := started.Command.Lookup("documents", "0")
+		if entry.Document().Lookup("version").Int64() != 1 {
+			t.Fatalf("unexpected history version: %v", entry)
+		}
+		if entry.Document().Lookup("direction").StringValue() != string(lightmigrate.Up) {
+			t.Fatalf("unexpected history direction: %v", entry)
+		}
+		if entry.Document().Lookup("checksum").StringValue() != checksum([]byte(`[{"ping": 1}]`)) {
+			t.Fatalf("unexpected history checksum: %v", entry)
+		}
+	})
+
+	mt.Run("CancelledContext", func(mt *mtest.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		d, err := NewDriver(mt.Client, "test", WithContext(ctx), WithHistory(HistoryConfig{Enabled: true}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		cancel() // e.g. SIGTERM during the migration
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // history entry
+
+		d.(*driver).recordHistory("", time.Now(), context.Canceled)
+
+		started := mt.GetStartedEvent()
+		if started == nil || started.CommandName != "insert" {
+			t.Fatalf("expected history insert, got: %v", started)
+		}
+	})
+
+	mt.Run("Disabled", func(mt *mtest.T) {
+		d, err := NewDriver(mt.Client, "test")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // migration command
+
+		err = d.RunMigration(bytes.NewReader([]byte(`[{"ping": 1}]`)))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.GetStartedEvent() // migration command
+		if started := mt.GetStartedEvent(); started != nil {
+			t.Fatalf("unexpected command: %v", started)
+		}
+	})
+
+	mt.Run("MigrationError", func(mt *mtest.T) {
+		d, err := NewDriver(mt.Client, "test", WithHistory(HistoryConfig{Enabled: true}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 0}}) // migration command
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // history entry
+
+		err = d.RunMigration(bytes.NewReader([]byte(`[{"ping": 1}]`)))
+		if err == nil {
+			t.Fatalf("expected error, got: %v", err)
+		}
+
+		mt.GetStartedEvent() // migration command
+		started := mt.GetStartedEvent()
+		if started == nil || started.CommandName != "insert" {
+			t.Fatalf("expected history insert, got: %v", started)
+		}
+		if _, lookupErr := started.Command.LookupErr("documents", "0", "error"); lookupErr != nil {
+			t.Fatalf("missing error in history entry")
+		}
+	})
+}
@@ -0,0 +1,72 @@
+package mongodb
+
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// redactedValue replaces the values of sensitive command fields in log messages and errors.
+const redactedValue = "<redacted>"
+
+// sensitiveFields contains all command fields (e.g. of createUser or updateUser) whose values must not be logged.
+var sensitiveFields = map[string]struct{}{
+	"pwd":      {},
+	"password": {},
+}
+
+// logMessage prints the given message using the configured logger. Without logger, the message is discarded.
+func (d *driver) logMessage(format string, v ...interface{}) {
+	if d.logger == nil {
+		return
+	}
+
+	d.logger.Printf(format, v...)
+}
+
+// logVerbose prints the given message using the configured logger, if verbose logging is enabled.
+func (d *driver) logVerbose(format string, v ...interface{}) {
+	if !d.verbose {
+		return
+	}
+
+	d.logMessage(format, v...)
+}
+
+// formatCommand returns the Extended JSON representation of the command, with all sensitive values redacted.
+func formatCommand(cmd bson.D) string {
+	out, err := bson.MarshalExtJSON(redactDocument(cmd), false, false)
+	if err != nil {
+		return fmt.Sprintf("%v", redactDocument(cmd))
+	}
+	return string(out)
+}
+
+// redactDocument returns a copy of the document where the values of all sensitive fields are redacted.
+func redactDocument(doc bson.D) bson.D {
+	redacted := make(bson.D, len(doc))
+	for i, elem := range doc {
+		if _, sensitive := sensitiveFields[elem.Key]; sensitive {
+			redacted[i] = bson.E{Key: elem.Key, Value: redactedValue}
+			continue
+		}
+		redacted[i] = bson.E{Key: elem.Key, Value: redactValue(elem.Value)}
+	}
+	return redacted
+}
+
+// redactValue redacts sensitive fields within nested documents and arrays.
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case bson.D:
+		return redactDocument(v)
+	case bson.A:
+		redacted := make(bson.A, len(v))
+		for i, elem := range v {
+			redacted[i] = redactValue(elem)
+		}
+		return redacted
+	default:
+		return value
+	}
+}
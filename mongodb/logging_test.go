@@ -0,0 +1,104 @@
+package mongodb
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+type testLogger struct {
+	messages []string
+}
+
+func (l *testLogger) Printf(format string, v ...interface{}) {
+	l.messages = append(l.messages, fmt.Sprintf(format, v...))
+}
+
+func Test_driver_logVerbose(t *testing.T) {
+	logger := &testLogger{}
+	d := &driver{logger: logger}
+
+	d.logVerbose("hidden")
+	if len(logger.messages) != 0 {
+		t.Fatalf("unexpected log messages: %v", logger.messages)
+	}
+
+	d.verbose = true
+	d.logVerbose("visible %d", 1)
+	if len(logger.messages) != 1 || logger.messages[0] != "visible 1" {
+		t.Fatalf("unexpected log messages: %v", logger.messages)
+	}
+}
+
+func Test_driver_logMessage(t *testing.T) {
+	d, err := NewDriver(&mongo.Client{}, "db", WithLogger(nil), WithVerboseLogging(true))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	d.(*driver).logMessage("discarded %d", 1) // must not panic
+	d.(*driver).logVerbose("discarded %d", 2)
+}
+
+func Test_formatCommand(t *testing.T) {
+	var cmds []bson.D
+	err := bson.UnmarshalExtJSON([]byte(`[{
+		"createUser": "deminem",
+		"pwd": "gogo",
+		"roles": [{"role": "readWrite", "db": "testMigration"}],
+		"nested": {"password": "secret"}
+	}]`), true, &cmds)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	formatted := formatCommand(cmds[0])
+	if strings.Contains(formatted, "gogo") || strings.Contains(formatted, "secret") {
+		t.Fatalf("secrets not redacted: %s", formatted)
+	}
+	if !strings.Contains(formatted, "deminem") || !strings.Contains(formatted, "readWrite") {
+		t.Fatalf("unexpected formatted command: %s", formatted)
+	}
+
+	// the original command must not be modified
+	if cmds[0][1].Value != "gogo" {
+		t.Fatalf("original command was modified: %v", cmds[0])
+	}
+}
+
+func Test_driver_executeCommands_VerboseLogging(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Redacted", func(mt *mtest.T) {
+		logger := &testLogger{}
+		d, err := NewDriver(mt.Client, "test", WithLogger(logger), WithVerboseLogging(true))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 0}})
+
+		cmd := bson.D{{Key: "createUser", Value: "deminem"}, {Key: "pwd", Value: "gogo"}}
+		err = d.(*driver).executeCommands(mt.Context(), []migrationCommand{{Command: cmd}})
+		if err == nil {
+			t.Fatalf("expected error, got: %v", err)
+		}
+		if strings.Contains(err.Error(), "gogo") {
+			t.Fatalf("secrets not redacted in error: %v", err)
+		}
+
+		if len(logger.messages) != 2 {
+			t.Fatalf("unexpected log messages: %v", logger.messages)
+		}
+		for _, msg := range logger.messages {
+			if strings.Contains(msg, "gogo") {
+				t.Fatalf("secrets not redacted in log: %s", msg)
+			}
+		}
+	})
+}
@@ -1,11 +1,16 @@
 package mongodb
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log"
 	"os"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -16,29 +21,76 @@ import (
 )
 
 type versionInfo struct {
-	Version int64 `bson:"version"`
-	Dirty   bool  `bson:"dirty"`
+	Version   int64              `bson:"version"`
+	Dirty     bool               `bson:"dirty"`
+	Checksums map[string]string  `bson:"checksums,omitempty"` // checksums of all applied up migrations by version
+	Progress  *migrationProgress `bson:"progress,omitempty"`  // progress of the in-flight migration
 }
 
 type lockObj struct {
 	Key       int       `bson:"locking_key"`
+	Scope     string    `bson:"scope"`
+	Owner     string    `bson:"owner"`
 	Pid       int       `bson:"pid"`
 	Hostname  string    `bson:"hostname"`
 	CreatedAt time.Time `bson:"created_at"`
+	ExpiresAt time.Time `bson:"expires_at,omitempty"`
 }
 
-type lockFilter struct {
-	Key int `bson:"locking_key"`
+// LockInfo describes the current holder of the migration lock.
+type LockInfo struct {
+	// Owner is the unique token of the lock acquisition.
+	Owner string
+	// Hostname is the host name of the process that holds the lock.
+	Hostname string
+	// Pid is the process id of the process that holds the lock.
+	Pid int
+	// CreatedAt is the time when the lock was acquired.
+	CreatedAt time.Time
+	// ExpiresAt is the time when the lock lease expires. It is zero if the lock never expires.
+	ExpiresAt time.Time
+}
+
+// Driver is the MongoDB migration driver. Besides the lightmigrate.MigrationDriver functionality, it allows
+// to inspect and forcefully release the migration lock.
+type Driver interface {
+	lightmigrate.MigrationDriver
+
+	// LockInfo returns information about the current holder of the migration lock.
+	// If the database is not locked, nil is returned. If locking is disabled, ErrLockingDisabled is returned.
+	LockInfo(ctx context.Context) (*LockInfo, error)
+
+	// ForceUnlock removes the migration lock, regardless of which process holds it.
+	// If locking is disabled, ErrLockingDisabled is returned.
+	ForceUnlock(ctx context.Context) error
 }
 
 type driver struct {
 	client            *mongo.Client
 	cfg               *config
-	migDb             *mongo.Database // where migration info is stored
-	reentrantLockFlag int32           // must be accessed by atomic.XXX functions!
+	ctx               context.Context    // base context for all MongoDB requests
+	migDb             *mongo.Database    // where migration info is stored
+	targetDb          *mongo.Database    // where migration commands are executed by default
+	reentrantLockFlag int32              // must be accessed by atomic.XXX functions!
+	lockOwner         string             // unique token of the currently held lock
+	appliedVersion    uint64             // last known clean migration version
+	pending           *pendingMigration  // migration that is currently applied or reverted
+	checksums         map[string]string  // last known checksums of the applied migrations
+	progress          *migrationProgress // progress of the in-flight migration
+	commandCount      int                // number of commands of the migration file that is currently run
+
+	checksumSource lightmigrate.MigrationSource // source used to verify the checksums of applied migrations
+	resultHook     CommandResultHook            // called after each executed migration command
+	goMigrations   MigrationRegistry            // migrations (or parts of them) implemented in Go
+
+	heartbeatMutex  sync.Mutex
+	heartbeatCancel context.CancelFunc // stops the lock heartbeat
+	heartbeatDone   chan struct{}      // closed once the lock heartbeat has stopped
+	lockLost        chan struct{}      // closed if the lock heartbeat detected that the lock was taken over
 
 	logger  lightmigrate.Logger
 	verbose bool
+	dryRun  bool
 }
 
 // DriverOption is a function that can be used within the driver constructor to
@@ -46,7 +98,7 @@ type driver struct {
 type DriverOption func(svc *driver)
 
 // NewDriver instantiates a new MongoDB driver. A MongoDB client and the database name are required arguments.
-func NewDriver(client *mongo.Client, database string, opts ...DriverOption) (lightmigrate.MigrationDriver, error) {
+func NewDriver(client *mongo.Client, database string, opts ...DriverOption) (Driver, error) {
 	if database == "" {
 		return nil, ErrNoDatabaseName
 	}
@@ -56,25 +108,60 @@ func NewDriver(client *mongo.Client, database string, opts ...DriverOption) (lig
 	}
 
 	cfg := &config{
-		DatabaseName:         database,
-		MigrationsCollection: DefaultMigrationsCollection,
-		TransactionMode:      false,
-		Locking:              LockingConfig{}, // no locking
+		DatabaseName:    database,
+		TransactionMode: false,
+		Transaction: TransactionConfig{
+			Timeout: DefaultTransactionTimeout,
+		},
+		Locking: LockingConfig{}, // no locking
+		Timeouts: TimeoutConfig{
+			Lock: DefaultLockTimeout,
+		},
 	}
 
 	d := &driver{
 		client: client,
 		cfg:    cfg,
+		ctx:    context.Background(),
+		logger: log.Default(),
 	}
 
 	for _, opt := range opts {
 		opt(d)
 	}
 
-	// setup migration database
-	d.migDb = d.client.Database(d.cfg.DatabaseName)
+	// setup migration databases, by default the migration info is stored next to the migrated data
+	if d.cfg.StateDatabaseName == "" {
+		d.cfg.StateDatabaseName = d.cfg.DatabaseName
+	}
+	d.migDb = d.client.Database(d.cfg.StateDatabaseName)
+	d.targetDb = d.client.Database(d.cfg.DatabaseName)
+	sharedStateDb := d.cfg.StateDatabaseName != d.cfg.DatabaseName
+
+	// setup migrations and history collections, a separate state database might be shared by several migrated
+	// databases, so each of them gets its own collections by default
+	if d.cfg.MigrationsCollection == "" {
+		d.cfg.MigrationsCollection = DefaultMigrationsCollection
+		if sharedStateDb {
+			d.cfg.MigrationsCollection = d.cfg.DatabaseName + "_" + DefaultMigrationsCollection
+		}
+	}
+	if d.cfg.History.CollectionName == "" {
+		d.cfg.History.CollectionName = DefaultHistoryCollection
+		if sharedStateDb {
+			d.cfg.History.CollectionName = d.cfg.DatabaseName + "_" + DefaultHistoryCollection
+		}
+	}
+
+	// setup lock scope, by default each migrations collection has its own lock
+	if d.cfg.Locking.Scope == "" {
+		d.cfg.Locking.Scope = d.cfg.MigrationsCollection
+	}
 
-	// setup locking
+	// setup locking, the lease must be renewed before it expires
+	if d.cfg.Locking.LeaseDuration > 0 && d.cfg.Locking.HeartbeatInterval >= d.cfg.Locking.LeaseDuration {
+		return nil, ErrInvalidHeartbeatInterval
+	}
 	if d.cfg.Locking.Enabled {
 		err := d.prepareLockCollection()
 		if err != nil {
@@ -85,7 +172,7 @@ func NewDriver(client *mongo.Client, database string, opts ...DriverOption) (lig
 	return d, nil
 }
 
-// WithLogger sets the logging instance used by the driver.
+// WithLogger sets the logging instance used by the driver. A nil logger disables all log messages of the driver.
 func WithLogger(logger lightmigrate.Logger) DriverOption {
 	return func(d *driver) {
 		d.logger = logger
@@ -99,13 +186,58 @@ func WithVerboseLogging(verbose bool) DriverOption {
 	}
 }
 
+// WithDryRun enables the dry run mode. In dry run mode, the commands of each migration are validated and logged
+// in execution order, but they are not executed. The migration version is not modified either.
+func WithDryRun(dryRun bool) DriverOption {
+	return func(d *driver) {
+		d.dryRun = dryRun
+	}
+}
+
+// WithCommandValidation allows to configure how migration commands with unknown names are handled, see
+// CommandValidation. Commands that are not known to the driver can be allowed by passing their (case-sensitive)
+// names as additional commands.
+func WithCommandValidation(validation CommandValidation, additionalCommands ...string) DriverOption {
+	return func(d *driver) {
+		d.cfg.CommandValidation = validation
+		for _, name := range additionalCommands {
+			if d.cfg.AdditionalCommands == nil {
+				d.cfg.AdditionalCommands = make(map[string]struct{})
+			}
+			d.cfg.AdditionalCommands[name] = struct{}{}
+		}
+	}
+}
+
 // WithMigrationCollection allows to specify the name of the collection that contains the migration state.
+// Defaults to DefaultMigrationsCollection, prefixed with the migrated database name if WithStateDatabase is used.
 func WithMigrationCollection(migrationCollection string) DriverOption {
 	return func(d *driver) {
 		d.cfg.MigrationsCollection = migrationCollection
 	}
 }
 
+// WithStateDatabase allows to specify the database that contains the migration state, the lock and the history
+// collections. By default, the database passed to NewDriver is used. Migration commands are still executed on the
+// database passed to NewDriver unless they specify their own target database.
+// As the state database can be shared by several migrated databases, the default migrations collection (and thus
+// the default lock scope) and the default history collection are prefixed with the name of the migrated database,
+// e.g. app_schema_migrations.
+func WithStateDatabase(database string) DriverOption {
+	return func(d *driver) {
+		d.cfg.StateDatabaseName = database
+	}
+}
+
+// WithResume allows enabling or disabling the resume mode. If enabled, a migration that was interrupted (dirty
+// version) is re-run on the next migration attempt, skipping all commands that have already been executed
+// successfully. Migrations that have changed since they were interrupted are not resumed.
+func WithResume(resume bool) DriverOption {
+	return func(d *driver) {
+		d.cfg.ResumeMode = resume
+	}
+}
+
 // WithTransactions allows enabling or disabling MongoDB transactions for the migration process.
 func WithTransactions(transactions bool) DriverOption {
 	return func(d *driver) {
@@ -113,6 +245,18 @@ func WithTransactions(transactions bool) DriverOption {
 	}
 }
 
+// WithTransactionConfig can be used to configure the timeout, read concern, write concern and read preference
+// of migration transactions. Transactions must be enabled separately, see WithTransactions.
+func WithTransactionConfig(transactionConfig TransactionConfig) DriverOption {
+	return func(d *driver) {
+		if transactionConfig.Timeout == 0 {
+			transactionConfig.Timeout = DefaultTransactionTimeout
+		}
+
+		d.cfg.Transaction = transactionConfig
+	}
+}
+
 // WithLocking can be used to configure the locking behaviour of the MongoDB migration driver.
 // See LockingConfig for details.
 func WithLocking(lockConfig LockingConfig) DriverOption {
@@ -123,17 +267,105 @@ func WithLocking(lockConfig LockingConfig) DriverOption {
 		if lockConfig.IndexName == "" {
 			lockConfig.IndexName = DefaultLockIndexName
 		}
+		if lockConfig.HeartbeatInterval == 0 {
+			lockConfig.HeartbeatInterval = lockConfig.LeaseDuration / 3
+		}
+		if lockConfig.RetryInterval == 0 {
+			lockConfig.RetryInterval = DefaultLockRetryInterval
+		}
+		if lockConfig.MaxRetryInterval == 0 {
+			lockConfig.MaxRetryInterval = DefaultLockMaxRetryInterval
+		}
 
 		d.cfg.Locking = lockConfig
 	}
 }
 
+// WithRetry can be used to retry migration commands that failed with a transient error. See RetryConfig for details.
+// Note that a command that failed because of a network error might have been applied by the server nevertheless,
+// so retries should only be enabled for idempotent commands or in transaction mode.
+func WithRetry(retryConfig RetryConfig) DriverOption {
+	return func(d *driver) {
+		if retryConfig.MaxAttempts == 0 {
+			retryConfig.MaxAttempts = DefaultRetryMaxAttempts
+		}
+		if retryConfig.RetryInterval == 0 {
+			retryConfig.RetryInterval = DefaultRetryInterval
+		}
+		if retryConfig.MaxRetryInterval == 0 {
+			retryConfig.MaxRetryInterval = DefaultRetryMaxInterval
+		}
+
+		d.cfg.Retry = retryConfig
+	}
+}
+
+// WithHistory can be used to enable the migration history, which stores one document per applied or reverted
+// migration. See HistoryConfig for details.
+func WithHistory(historyConfig HistoryConfig) DriverOption {
+	return func(d *driver) {
+		d.cfg.History = historyConfig
+	}
+}
+
+// WithChecksumVerification enables the detection of migration files that have been modified after they were applied.
+// Re-applied migrations are always verified against the checksum recorded when they were applied the last time.
+// If a source is given, the checksums of all applied migrations are verified whenever the version is read.
+func WithChecksumVerification(verification ChecksumVerification, source lightmigrate.MigrationSource) DriverOption {
+	return func(d *driver) {
+		d.cfg.ChecksumVerification = verification
+		d.checksumSource = source
+	}
+}
+
+// WithCommandResultHook sets a hook that gets called with the result of each executed migration command.
+// It can be used to report affected document counts and durations, e.g. to audit logs.
+func WithCommandResultHook(hook CommandResultHook) DriverOption {
+	return func(d *driver) {
+		d.resultHook = hook
+	}
+}
+
+// WithGoMigrations registers migrations that are implemented in Go. The registered function of a version
+// is run after the commands of the corresponding migration file, within the same transaction if enabled.
+func WithGoMigrations(registry MigrationRegistry) DriverOption {
+	return func(d *driver) {
+		d.goMigrations = registry
+	}
+}
+
+// WithContext sets the base context of the driver. All MongoDB requests are derived from this context,
+// so cancelling it aborts all running and future requests of the driver. Releasing the lock and recording
+// the migration history are still possible afterwards, bounded by TimeoutConfig.Lock.
+func WithContext(ctx context.Context) DriverOption {
+	return func(d *driver) {
+		d.ctx = ctx
+	}
+}
+
+// WithTimeouts allows to bound the duration of the MongoDB requests issued by the driver.
+// See TimeoutConfig for details.
+func WithTimeouts(timeouts TimeoutConfig) DriverOption {
+	return func(d *driver) {
+		if timeouts.Lock == 0 {
+			timeouts.Lock = DefaultLockTimeout
+		}
+
+		d.cfg.Timeouts = timeouts
+	}
+}
+
 func (d *driver) Close() error {
-	return nil // nothing to clean up
+	_ = d.stopHeartbeat()
+	return nil
 }
 
 // Lock utilizes advisory locking on the LockingConfig.CollectionName collection
-// This uses a unique index on the `locking_key` field.
+// This uses a unique index on the `locking_key` field, or on the `locking_key` and `scope` fields if
+// LockingConfig.Scope is set.
+// If LockingConfig.LeaseDuration is set, an expired lock of another process will be taken over and
+// the lease of the acquired lock will be renewed in the background until Unlock is called.
+// If LockingConfig.WaitTimeout is set, Lock waits until the lock is released or the timeout is reached.
 func (d *driver) Lock() error {
 	if !d.cfg.Locking.Enabled {
 		return nil
@@ -144,27 +376,154 @@ func (d *driver) Lock() error {
 		return nil // no swap happened, already locked
 	}
 
+	newLockObj, err := d.acquireLock()
+	if err != nil {
+		atomic.StoreInt32(&d.reentrantLockFlag, 0) // restore unlock flag
+		return err
+	}
+
+	d.lockOwner = newLockObj.Owner
+	if d.cfg.Locking.LeaseDuration > 0 {
+		d.startHeartbeat(newLockObj)
+	}
+
+	d.logVerbose("acquired migration lock (scope %s, owner %s)", newLockObj.Scope, newLockObj.Owner)
+
+	return nil
+}
+
+// acquireLock tries to acquire the lock. If the lock is held by another process, acquisition is retried
+// with an exponential backoff until LockingConfig.WaitTimeout is reached.
+func (d *driver) acquireLock() (lockObj, error) {
+	newLockObj, err := d.tryLock()
+	if err != ErrDatabaseLocked || d.cfg.Locking.WaitTimeout <= 0 {
+		return newLockObj, err
+	}
+
+	ctx, cancelFunc := d.operationContext(d.cfg.Locking.WaitTimeout)
+	defer cancelFunc()
+
+	lockReleased := d.watchLockReleases(ctx) // nil if change streams are not available
+	backoff := d.cfg.Locking.RetryInterval
+	if backoff <= 0 {
+		backoff = DefaultLockRetryInterval
+	}
+	for {
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return lockObj{}, ErrDatabaseLocked
+		case <-lockReleased:
+			timer.Stop()
+		case <-timer.C:
+		}
+
+		newLockObj, err = d.tryLock()
+		if err != ErrDatabaseLocked {
+			return newLockObj, err
+		}
+
+		backoff *= 2
+		if d.cfg.Locking.MaxRetryInterval > 0 && backoff > d.cfg.Locking.MaxRetryInterval {
+			backoff = d.cfg.Locking.MaxRetryInterval
+		}
+	}
+}
+
+// tryLock performs a single attempt to insert the lock object. If the lock is held by another process,
+// ErrDatabaseLocked is returned.
+func (d *driver) tryLock() (lockObj, error) {
 	pid := os.Getpid()
 	hostname, err := os.Hostname()
 	if err != nil {
 		hostname = fmt.Sprintf("unknown-host-%d", pid) // use pid as fallback
 	}
 
+	owner, err := newOwnerToken()
+	if err != nil {
+		return lockObj{}, err
+	}
+
+	now := time.Now()
 	newLockObj := lockObj{
 		Key:       lockKeyUniqueValue,
+		Scope:     d.cfg.Locking.Scope,
+		Owner:     owner,
 		Pid:       pid,
 		Hostname:  hostname,
-		CreatedAt: time.Now(),
+		CreatedAt: now,
+	}
+	if d.cfg.Locking.LeaseDuration > 0 {
+		newLockObj.ExpiresAt = now.Add(d.cfg.Locking.LeaseDuration)
 	}
 
-	ctx, cancelFunc := context.WithTimeout(context.Background(), contextWaitTimeout)
+	ctx, cancelFunc := d.operationContext(d.cfg.Timeouts.Lock)
 	defer cancelFunc()
 	_, err = d.migDb.Collection(d.cfg.Locking.CollectionName).InsertOne(ctx, newLockObj)
+	if err != nil && d.cfg.Locking.LeaseDuration > 0 && mongo.IsDuplicateKeyError(err) {
+		err = d.takeOverExpiredLock(ctx, newLockObj)
+	}
 	if err != nil {
-		atomic.StoreInt32(&d.reentrantLockFlag, 0) // restore unlock flag
-		return ErrDatabaseLocked
+		return lockObj{}, ErrDatabaseLocked
 	}
 
+	return newLockObj, nil
+}
+
+// newOwnerToken generates a random (version 4) UUID that uniquely identifies a lock acquisition.
+func newOwnerToken() (string, error) {
+	var uuid [16]byte
+	if _, err := rand.Read(uuid[:]); err != nil {
+		return "", fmt.Errorf("failed to generate lock owner token: %w", err)
+	}
+	uuid[6] = (uuid[6] & 0x0f) | 0x40 // version 4
+	uuid[8] = (uuid[8] & 0x3f) | 0x80 // variant 10
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:]), nil
+}
+
+// watchLockReleases opens a change stream on the locking collection. The returned channel receives a value
+// whenever a lock object gets removed or modified. If change streams are not supported by the
+// database deployment (e.g. a standalone server), nil is returned.
+func (d *driver) watchLockReleases(ctx context.Context) <-chan struct{} {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"operationType": bson.M{"$in": bson.A{"delete", "replace", "update"}}}}},
+	}
+	stream, err := d.migDb.Collection(d.cfg.Locking.CollectionName).Watch(ctx, pipeline)
+	if err != nil {
+		return nil
+	}
+
+	released := make(chan struct{}, 1)
+	go func() {
+		defer stream.Close(context.Background())
+		for stream.Next(ctx) {
+			select {
+			case released <- struct{}{}:
+			default: // there is already a pending notification
+			}
+		}
+	}()
+
+	return released
+}
+
+// takeOverExpiredLock atomically replaces the lock object of another process if its lease has expired.
+func (d *driver) takeOverExpiredLock(ctx context.Context, newLockObj lockObj) error {
+	filter := d.lockFilter("")
+	filter["expires_at"] = bson.M{"$lt": newLockObj.CreatedAt}
+
+	var oldLockObj lockObj
+	err := d.migDb.Collection(d.cfg.Locking.CollectionName).FindOneAndReplace(ctx, filter, newLockObj).Decode(&oldLockObj)
+	if err != nil {
+		return err
+	}
+
+	d.logMessage("took over expired migration lock of %s (pid %d), created at %s, expired at %s",
+		oldLockObj.Hostname, oldLockObj.Pid, oldLockObj.CreatedAt.Format(time.RFC3339),
+		oldLockObj.ExpiresAt.Format(time.RFC3339))
+
 	return nil
 }
 
@@ -178,119 +537,586 @@ func (d *driver) Unlock() error {
 		return nil // no swap happened, already unlocked
 	}
 
-	filter := lockFilter{
-		Key: lockKeyUniqueValue,
+	if lost := d.stopHeartbeat(); lost {
+		return ErrLockLost // the lock object belongs to another process now
 	}
 
-	ctx, cancelFunc := context.WithTimeout(context.Background(), contextWaitTimeout)
+	// only delete the lock object if it is still owned by this driver
+	filter := d.lockFilter(d.lockOwner)
+
+	ctx, cancelFunc := d.cleanupContext()
 	defer cancelFunc()
-	_, err := d.migDb.Collection(d.cfg.Locking.CollectionName).DeleteMany(ctx, filter)
+	result, err := d.migDb.Collection(d.cfg.Locking.CollectionName).DeleteOne(ctx, filter)
 	if err != nil {
 		atomic.StoreInt32(&d.reentrantLockFlag, 1) // restore lock flag
 		return err
 	}
+	if result.DeletedCount == 0 {
+		return ErrLockLost // the lock object belongs to another process now
+	}
+
+	d.logVerbose("released migration lock (scope %s, owner %s)", d.cfg.Locking.Scope, d.lockOwner)
+
+	return nil
+}
+
+func (d *driver) LockInfo(ctx context.Context) (*LockInfo, error) {
+	if !d.cfg.Locking.Enabled {
+		return nil, ErrLockingDisabled // the lock collection is unknown, so the lock state can not be determined
+	}
+
+	var currentLock lockObj
+	err := d.migDb.Collection(d.cfg.Locking.CollectionName).FindOne(ctx, d.lockFilter("")).
+		Decode(&currentLock)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		return nil, nil
+	case err != nil:
+		return nil, &lightmigrate.DriverError{OrigErr: err, Msg: "failed to get lock info"}
+	default:
+		return currentLock.info(), nil
+	}
+}
+
+func (d *driver) ForceUnlock(ctx context.Context) error {
+	if !d.cfg.Locking.Enabled {
+		return ErrLockingDisabled
+	}
+
+	var evictedLock lockObj
+	err := d.migDb.Collection(d.cfg.Locking.CollectionName).FindOneAndDelete(ctx, d.lockFilter("")).
+		Decode(&evictedLock)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		return nil // not locked
+	case err != nil:
+		return &lightmigrate.DriverError{OrigErr: err, Msg: "failed to force unlock"}
+	}
+
+	d.logMessage("forcefully released migration lock of %s (pid %d, owner %s), created at %s",
+		evictedLock.Hostname, evictedLock.Pid, evictedLock.Owner, evictedLock.CreatedAt.Format(time.RFC3339))
 
 	return nil
 }
 
+// lockFilter returns the filter that matches the lock object of the configured scope. If owner is not empty,
+// only the lock object of the given owner is matched. In legacy compatibility mode, lock objects without scope
+// (written by driver versions without lock scopes) are matched as well.
+func (d *driver) lockFilter(owner string) bson.M {
+	filter := bson.M{"locking_key": lockKeyUniqueValue, "scope": d.cfg.Locking.Scope}
+	if d.cfg.Locking.LegacyCompatibility {
+		filter["scope"] = bson.M{"$in": bson.A{d.cfg.Locking.Scope, nil}}
+	}
+	if owner != "" {
+		filter["owner"] = owner
+	}
+	return filter
+}
+
+// info converts the lock object to the exported LockInfo representation.
+func (l lockObj) info() *LockInfo {
+	return &LockInfo{
+		Owner:     l.Owner,
+		Hostname:  l.Hostname,
+		Pid:       l.Pid,
+		CreatedAt: l.CreatedAt,
+		ExpiresAt: l.ExpiresAt,
+	}
+}
+
+// startHeartbeat starts a background routine that periodically renews the lease of the given lock object.
+func (d *driver) startHeartbeat(lock lockObj) {
+	d.heartbeatMutex.Lock()
+	defer d.heartbeatMutex.Unlock()
+
+	ctx, cancel := d.operationContext(0)
+	d.heartbeatCancel = cancel
+	d.heartbeatDone = make(chan struct{})
+	d.lockLost = make(chan struct{})
+
+	go d.heartbeat(ctx, lock, d.heartbeatDone, d.lockLost)
+}
+
+// stopHeartbeat stops the lock heartbeat (if running) and waits until it has finished.
+// It returns true if the heartbeat detected that the lock was taken over by another process.
+func (d *driver) stopHeartbeat() (lost bool) {
+	d.heartbeatMutex.Lock()
+	defer d.heartbeatMutex.Unlock()
+
+	if d.heartbeatCancel == nil {
+		return false // not running
+	}
+
+	d.heartbeatCancel()
+	<-d.heartbeatDone
+
+	select {
+	case <-d.lockLost:
+		lost = true
+	default:
+	}
+
+	d.heartbeatCancel = nil
+	d.heartbeatDone = nil
+	d.lockLost = nil
+
+	return lost
+}
+
+// heartbeat renews the lease of the lock until the context is cancelled or the lock has been taken over.
+func (d *driver) heartbeat(ctx context.Context, lock lockObj, done, lost chan struct{}) {
+	defer close(done)
+
+	interval := d.cfg.Locking.HeartbeatInterval
+	if interval <= 0 {
+		interval = d.cfg.Locking.LeaseDuration / 3
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	filter := d.lockFilter(lock.Owner)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		update := bson.M{"$set": bson.M{"expires_at": time.Now().Add(d.cfg.Locking.LeaseDuration)}}
+
+		renewCtx, cancelFunc := withOptionalTimeout(ctx, d.cfg.Timeouts.Lock)
+		result, err := d.migDb.Collection(d.cfg.Locking.CollectionName).UpdateOne(renewCtx, filter, update)
+		cancelFunc()
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			d.logMessage("failed to renew migration lock, retrying: %v", err) // lease might still be valid
+		case result.MatchedCount == 0:
+			d.logMessage("migration lock was taken over by another process")
+			close(lost)
+			return
+		}
+	}
+}
+
+// isLockLost returns true if the lock heartbeat detected that the lock was taken over.
+func (d *driver) isLockLost() bool {
+	select {
+	case <-d.lockLostChannel():
+		return true
+	default:
+		return false
+	}
+}
+
+// lockLostChannel returns a channel that gets closed once the lock was taken over by another process.
+// If no lock heartbeat was started, nil is returned.
+func (d *driver) lockLostChannel() chan struct{} {
+	d.heartbeatMutex.Lock()
+	defer d.heartbeatMutex.Unlock()
+
+	return d.lockLost
+}
+
+// migrationContext returns a context that gets cancelled if the lock is taken over by another process.
+func (d *driver) migrationContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := d.operationContext(d.cfg.Timeouts.Migration)
+
+	lost := d.lockLostChannel()
+	if lost != nil {
+		go func() {
+			select {
+			case <-lost:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	return ctx, cancel
+}
+
+// operationContext returns a context derived from the base context of the driver. If timeout is greater than
+// zero, the context gets cancelled after the given duration.
+func (d *driver) operationContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx := d.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	return withOptionalTimeout(ctx, timeout)
+}
+
+// cleanupContext returns a context that is detached from the cancellation of the base context, so that the lock
+// can be released and the history can be recorded even if the migration was aborted by cancelling the base context.
+// The context gets cancelled after TimeoutConfig.Lock.
+func (d *driver) cleanupContext() (context.Context, context.CancelFunc) {
+	timeout := d.cfg.Timeouts.Lock
+	if timeout <= 0 {
+		timeout = DefaultLockTimeout
+	}
+
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// withOptionalTimeout derives a cancelable context from the parent context. If timeout is greater than zero,
+// the context gets cancelled after the given duration.
+func withOptionalTimeout(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(parent, timeout)
+	}
+	return context.WithCancel(parent)
+}
+
 func (d *driver) GetVersion() (version uint64, dirty bool, err error) {
+	ctx, cancelFunc := d.operationContext(d.cfg.Timeouts.Version)
+	defer cancelFunc()
+
 	var versionInfo versionInfo
-	err = d.migDb.Collection(d.cfg.MigrationsCollection).FindOne(context.TODO(), bson.M{}).Decode(&versionInfo)
+	err = d.migDb.Collection(d.cfg.MigrationsCollection).FindOne(ctx, bson.M{}).Decode(&versionInfo)
 	switch {
 	case err == mongo.ErrNoDocuments:
+		d.logVerbose("no migration version found")
 		return lightmigrate.NoMigrationVersion, false, nil
 	case err != nil:
 		return 0, false, &lightmigrate.DriverError{OrigErr: err, Msg: "failed to get migration version"}
 	default:
+		d.logVerbose("read migration version %d (dirty: %t)", versionInfo.Version, versionInfo.Dirty)
+		if !versionInfo.Dirty {
+			d.appliedVersion = uint64(versionInfo.Version)
+		}
+		d.checksums = versionInfo.Checksums
+		if err = d.verifyChecksums(versionInfo.Checksums, uint64(versionInfo.Version)); err != nil {
+			return 0, false, err
+		}
+		if versionInfo.Dirty && d.cfg.ResumeMode && versionInfo.Progress != nil {
+			// report the version before the interrupted migration, so that it gets run again
+			d.progress = versionInfo.Progress
+			d.appliedVersion = uint64(versionInfo.Progress.BaseVersion)
+			d.logMessage("migration %d (%s) was interrupted after %d command(s), it will be resumed",
+				versionInfo.Progress.Version, versionInfo.Progress.Direction, versionInfo.Progress.Completed)
+			return d.appliedVersion, false, nil
+		}
 		return uint64(versionInfo.Version), versionInfo.Dirty, nil
 	}
 }
 
+// SetVersion atomically updates (or inserts) the version document. The migrations collection itself,
+// including its indexes, validators and permissions, stays untouched.
+// Once a migration has been applied successfully, its checksum is stored alongside the version.
 func (d *driver) SetVersion(version uint64, dirty bool) error {
-	migrationsCollection := d.migDb.Collection(d.cfg.MigrationsCollection)
-	if err := migrationsCollection.Drop(context.TODO()); err != nil {
-		return &lightmigrate.DriverError{OrigErr: err, Msg: "drop migrations collection failed"}
+	if d.dryRun {
+		d.logVerbose("dry run: skipped saving migration version %d (dirty: %t)", version, dirty)
+		d.trackVersion(version, dirty)
+		return nil
 	}
-	_, err := migrationsCollection.InsertOne(context.TODO(), versionInfo{
-		Version: int64(version),
-		Dirty:   dirty,
-	})
+
+	if !dirty && d.pending != nil && d.pending.VersionSaved && d.pending.Target == version {
+		d.logVerbose("migration version %d was already saved within the migration transaction", version)
+		d.progress = nil
+		d.trackVersion(version, dirty)
+		return nil
+	}
+
+	ctx, cancelFunc := d.operationContext(d.cfg.Timeouts.Version)
+	defer cancelFunc()
+
+	update, progress := d.versionUpdate(version, dirty)
+	migrationsCollection := d.migDb.Collection(d.cfg.MigrationsCollection)
+	_, err := migrationsCollection.UpdateOne(ctx, bson.M{}, update, options.Update().SetUpsert(true))
 	if err != nil {
 		return &lightmigrate.DriverError{OrigErr: err, Msg: "save version failed"}
 	}
+	d.logVerbose("saved migration version %d (dirty: %t)", version, dirty)
+
+	d.progress = progress
+	d.trackVersion(version, dirty)
+
 	return nil
 }
 
+// versionUpdate builds the update of the version document and returns the progress of the in-flight migration.
+func (d *driver) versionUpdate(version uint64, dirty bool) (bson.M, *migrationProgress) {
+	set := bson.M{"version": int64(version), "dirty": dirty}
+	unset := bson.M{}
+	update := bson.M{"$set": set}
+	if !dirty && d.pending != nil && d.pending.Checksum != "" && d.pending.Direction == lightmigrate.Up {
+		set[checksumField(d.pending.Version)] = d.pending.Checksum // kept when reverting, see verifyPendingChecksum
+	}
+
+	// track the progress of the in-flight migration, unless an interrupted run of the same migration is resumed
+	progress := d.progress
+	if dirty {
+		pending := newPendingMigration(d.appliedVersion, version)
+		if !d.cfg.ResumeMode || !progress.matches(pending) {
+			progress = &migrationProgress{Version: int64(pending.Version), Direction: pending.Direction,
+				BaseVersion: int64(d.appliedVersion)}
+			set[progressField] = progress
+		}
+	} else {
+		progress = nil
+		unset[progressField] = ""
+	}
+	if len(unset) != 0 {
+		update["$unset"] = unset
+	}
+
+	return update, progress
+}
+
+// saveVersionInTransaction stores the target version of the pending migration as clean version within the
+// migration transaction, if enabled. See TransactionConfig.IncludeVersion.
+func (d *driver) saveVersionInTransaction(ctx context.Context) error {
+	if !d.cfg.Transaction.IncludeVersion || d.pending == nil {
+		return nil
+	}
+
+	update, _ := d.versionUpdate(d.pending.Target, false)
+	migrationsCollection := d.migDb.Collection(d.cfg.MigrationsCollection)
+	_, err := migrationsCollection.UpdateOne(ctx, bson.M{}, update, options.Update().SetUpsert(true))
+	if err != nil {
+		return &lightmigrate.DriverError{OrigErr: err, Msg: "save version in transaction failed"}
+	}
+	d.logVerbose("saved migration version %d within the migration transaction", d.pending.Target)
+	return nil
+}
+
+// trackVersion keeps track of the migration state, lightmigrate marks the target version as dirty before running
+// a migration and clean after the migration was successful.
+func (d *driver) trackVersion(version uint64, dirty bool) {
+	if dirty {
+		d.pending = newPendingMigration(d.appliedVersion, version)
+	} else {
+		d.updateChecksums()
+		d.appliedVersion = version
+		d.pending = nil
+	}
+}
+
 func (d *driver) RunMigration(migration io.Reader) error {
 	migr, err := ioutil.ReadAll(migration)
 	if err != nil {
 		return err
 	}
 
-	var cmds []bson.D
-	err = bson.UnmarshalExtJSON(migr, true, &cmds)
+	goMigration := d.goMigration()
+
+	var cmds []migrationCommand
+	if goMigration == nil || len(bytes.TrimSpace(migr)) != 0 {
+		cmds, err = decodeMigration(migr, d.cfg.DatabaseName)
+	}
+	if err == nil {
+		err = d.validateCommands(cmds)
+	}
+	if err != nil {
+		var formatErr MigrationFormatError
+		if errors.As(err, &formatErr) && d.pending != nil {
+			formatErr.Version = d.pending.Version
+			return formatErr
+		}
+		return err
+	}
+	if d.dryRun {
+		return d.planMigration(cmds)
+	}
+
+	ctx, cancel := d.migrationContext()
+	defer cancel()
+
+	if d.isLockLost() {
+		return ErrLockLost
+	}
+
+	sum := checksum(migr)
+	if err = d.verifyPendingChecksum(sum); err != nil {
+		return err
+	}
+
+	skip, err := d.resumeOffset(sum, len(cmds))
 	if err != nil {
-		return fmt.Errorf("unmarshaling json error: %s", err)
+		return err
+	}
+	d.commandCount = len(cmds)
+	cmds = cmds[skip:] // the commands keep their index within the migration file
+	if d.progress != nil {
+		d.progress.Checksum = sum
+	}
+	if d.pending != nil {
+		d.pending.Checksum = sum
 	}
+	if d.resumesCommitted() {
+		return nil // only the clean migration version is missing
+	}
+
+	startedAt := time.Now()
 	if d.cfg.TransactionMode {
-		err = d.executeCommandsWithTransaction(context.TODO(), cmds)
+		err = d.executeTransactional(ctx, cmds, goMigration)
 	} else {
-		err = d.executeCommands(context.TODO(), cmds)
+		err = d.executeMigration(ctx, cmds, goMigration)
+	}
+	d.recordHistory(sum, startedAt, err)
+	if err == nil && d.pending != nil && d.cfg.TransactionMode {
+		d.pending.VersionSaved = d.cfg.Transaction.IncludeVersion
+	}
+
+	if err != nil && d.isLockLost() {
+		return ErrLockLost
 	}
 
 	return err
 }
 
 func (d *driver) Reset() error {
+	ctx, cancelFunc := d.operationContext(d.cfg.Timeouts.Version)
+	defer cancelFunc()
+
 	migrationsCollection := d.migDb.Collection(d.cfg.MigrationsCollection)
-	if err := migrationsCollection.Drop(context.TODO()); err != nil {
+	if err := migrationsCollection.Drop(ctx); err != nil {
 		return &lightmigrate.DriverError{OrigErr: err, Msg: "drop migrations collection failed"}
 	}
+	if d.cfg.History.Enabled {
+		if err := d.migDb.Collection(d.cfg.History.CollectionName).Drop(ctx); err != nil {
+			return &lightmigrate.DriverError{OrigErr: err, Msg: "drop history collection failed"}
+		}
+	}
 	return nil
 }
 
-func (d *driver) executeCommandsWithTransaction(ctx context.Context, cmds []bson.D) error {
-	err := d.client.UseSession(ctx, func(sessionContext mongo.SessionContext) error {
-		if err := sessionContext.StartTransaction(); err != nil {
-			return &lightmigrate.DriverError{OrigErr: err, Msg: "failed to start transaction"}
-		}
-		if err := d.executeCommands(sessionContext, cmds); err != nil {
-			// When command execution failed, MongoDB has aborted the transaction
-			// Calling abortTransaction will return an error that the transaction is already aborted
-			return err
-		}
-		if err := sessionContext.CommitTransaction(sessionContext); err != nil {
-			return &lightmigrate.DriverError{OrigErr: err, Msg: "failed to commit transaction"}
-		}
-		return nil
+// runTransaction runs the function within a transaction. Transient transaction errors and unknown commit results
+// are retried by the MongoDB client until the transaction timeout (or the client's own limit of 120 seconds) is
+// reached. The transaction timeout also bounds all retries of the transaction, see WithRetry.
+func (d *driver) runTransaction(ctx context.Context, fn func(txnContext mongo.SessionContext) error) error {
+	txnCtx, cancel := withOptionalTimeout(ctx, d.cfg.Transaction.Timeout)
+	defer cancel()
+
+	// transient errors abort the whole transaction, so retries are only possible for the transaction as a whole
+	return d.withRetry(txnCtx, "transaction", func() error {
+		return d.client.UseSession(txnCtx, func(sessionContext mongo.SessionContext) error {
+			_, err := sessionContext.WithTransaction(sessionContext, func(txnContext mongo.SessionContext) (interface{}, error) {
+				d.logVerbose("started transaction")
+				return nil, fn(txnContext)
+			}, d.transactionOptions())
+
+			var driverErr *lightmigrate.DriverError
+			switch {
+			case err == nil:
+				d.logVerbose("committed transaction")
+				return nil
+			case errors.As(err, &driverErr): // the migration itself failed
+				d.logVerbose("aborted transaction")
+				return err
+			default:
+				d.logVerbose("failed to commit transaction: %v", err)
+				return &lightmigrate.DriverError{OrigErr: err, Msg: "failed to commit transaction"}
+			}
+		})
 	})
-	if err != nil {
-		return err
+}
+
+// transactionOptions returns the configured options for migration transactions.
+func (d *driver) transactionOptions() *options.TransactionOptions {
+	opts := options.Transaction()
+	if d.cfg.Transaction.ReadConcern != nil {
+		opts.SetReadConcern(d.cfg.Transaction.ReadConcern)
 	}
-	return nil
+	if d.cfg.Transaction.WriteConcern != nil {
+		opts.SetWriteConcern(d.cfg.Transaction.WriteConcern)
+	}
+	if d.cfg.Transaction.ReadPreference != nil {
+		opts.SetReadPreference(d.cfg.Transaction.ReadPreference)
+	}
+	return opts
 }
 
-func (d *driver) executeCommands(ctx context.Context, cmds []bson.D) error {
+// executeCommands runs the given commands of the migration file. Commands are logged and reported with their
+// position within the migration file, see migrationCommand.Index.
+func (d *driver) executeCommands(ctx context.Context, cmds []migrationCommand) error {
+	total := d.commandCount
+	if total < len(cmds) {
+		total = len(cmds) // not run by RunMigration
+	}
 	for _, cmd := range cmds {
-		err := d.migDb.RunCommand(ctx, cmd).Err()
+		i := cmd.Index
+		db := d.commandDatabase(cmd)
+		d.logVerbose("executing command %d/%d on %s: %s", i+1, total, db.Name(), formatCommand(cmd.Command))
+		start := time.Now()
+		var reply bson.Raw
+		run := func() error {
+			var err error
+			reply, err = db.RunCommand(ctx, cmd.Command).DecodeBytes()
+			if err == nil {
+				// statements of write commands can fail even though the command itself succeeded (ok: 1)
+				return writeErrorsFromReply(commandName(cmd.Command), reply)
+			}
+			return asCommandWriteError(commandName(cmd.Command), err)
+		}
+		var err error
+		if mongo.SessionFromContext(ctx) != nil {
+			err = run() // retried as part of the whole transaction
+		} else {
+			err = d.withRetry(ctx, fmt.Sprintf("command %d/%d", i+1, total), run)
+		}
+		if d.resultHook != nil {
+			d.resultHook(newCommandResult(i, db.Name(), cmd.Command, reply, time.Since(start), err))
+		}
 		if err != nil {
-			return &lightmigrate.DriverError{OrigErr: err, Msg: fmt.Sprintf("failed to execute command: %v", cmd)}
+			d.logVerbose("command %d/%d failed after %s: %v", i+1, total, time.Since(start), err)
+			return &lightmigrate.DriverError{OrigErr: err,
+				Msg: fmt.Sprintf("failed to execute command on %s: %s", db.Name(), formatCommand(cmd.Command))}
+		}
+		d.logVerbose("command %d/%d finished in %s", i+1, total, time.Since(start))
+		if err := d.checkpoint(ctx); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-// prepareLockCollection ensures that there exists a unique index for the locking key
+// commandDatabase returns the database the command should be executed on.
+func (d *driver) commandDatabase(cmd migrationCommand) *mongo.Database {
+	if cmd.Database == "" {
+		return d.targetDb
+	}
+	return d.client.Database(cmd.Database)
+}
+
+// prepareLockCollection ensures that there exists a unique index for the locking key and scope. In legacy
+// compatibility mode, the unique index for the locking key of driver versions without lock scopes is used instead.
+// An existing index with the same name but a different specification is never dropped automatically.
 func (d *driver) prepareLockCollection() error {
-	indexes := d.migDb.Collection(d.cfg.Locking.CollectionName).Indexes()
+	ctx, cancelFunc := d.operationContext(d.cfg.Timeouts.Index)
+	defer cancelFunc()
 
-	indexOptions := options.Index().SetUnique(true).SetName(d.cfg.Locking.IndexName)
-	_, err := indexes.CreateOne(context.TODO(), mongo.IndexModel{
-		Options: indexOptions,
-		Keys:    lockFilter{Key: -1},
-	})
+	keys := bson.D{{Key: "locking_key", Value: -1}, {Key: "scope", Value: -1}}
+	if d.cfg.Locking.LegacyCompatibility {
+		keys = bson.D{{Key: "locking_key", Value: -1}}
+	}
+	indexModel := mongo.IndexModel{
+		Options: options.Index().SetUnique(true).SetName(d.cfg.Locking.IndexName),
+		Keys:    keys,
+	}
+
+	_, err := d.migDb.Collection(d.cfg.Locking.CollectionName).Indexes().CreateOne(ctx, indexModel)
+	if isIndexConflictError(err) {
+		return fmt.Errorf("lock index %s of collection %s has a different specification, drop it once no "+
+			"migration process uses it anymore (or change the legacy compatibility setting): %w",
+			d.cfg.Locking.IndexName, d.cfg.Locking.CollectionName, err)
+	}
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+// isIndexConflictError checks if the given error signals that an index with the same name but different
+// keys or options already exists.
+func isIndexConflictError(err error) bool {
+	var cmdErr mongo.CommandError
+	if !errors.As(err, &cmdErr) {
+		return false
+	}
+
+	return cmdErr.Code == 85 || cmdErr.Code == 86 // IndexOptionsConflict, IndexKeySpecsConflict
+}
@@ -8,9 +8,13 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 	"log"
 	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestNewDriver(t *testing.T) {
@@ -29,6 +33,27 @@ func TestNewDriver_WithLocking(t *testing.T) {
 	}
 }
 
+func TestNewDriver_LockScope(t *testing.T) {
+	d, err := NewDriver(&mongo.Client{}, "db", WithMigrationCollection("tenant_migrations"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d.(*driver).cfg.Locking.Scope != "tenant_migrations" {
+		t.Fatalf("unexpected lock scope: %s", d.(*driver).cfg.Locking.Scope)
+	}
+}
+
+func TestNewDriver_InvalidHeartbeatInterval(t *testing.T) {
+	_, err := NewDriver(&mongo.Client{}, "db", WithLocking(LockingConfig{
+		Enabled:           true,
+		LeaseDuration:     time.Minute,
+		HeartbeatInterval: time.Minute,
+	}))
+	if err != ErrInvalidHeartbeatInterval {
+		t.Fatalf("expected ErrInvalidHeartbeatInterval, got: %v", err)
+	}
+}
+
 func TestNewDriver_NoDb(t *testing.T) {
 	_, err := NewDriver(nil, "")
 	if err == nil {
@@ -46,9 +71,11 @@ func TestNewDriver_NoClient(t *testing.T) {
 func TestWithLocking(t *testing.T) {
 	d := &driver{cfg: &config{}}
 	lockCfg := LockingConfig{
-		CollectionName: "a",
-		IndexName:      "b",
-		Enabled:        true,
+		CollectionName:   "a",
+		IndexName:        "b",
+		Enabled:          true,
+		RetryInterval:    time.Second,
+		MaxRetryInterval: time.Minute,
 	}
 
 	WithLocking(lockCfg)(d)
@@ -57,6 +84,27 @@ func TestWithLocking(t *testing.T) {
 	}
 }
 
+func TestWithLocking_Defaults(t *testing.T) {
+	d := &driver{cfg: &config{}}
+
+	WithLocking(LockingConfig{Enabled: true, LeaseDuration: time.Minute})(d)
+	if d.cfg.Locking.CollectionName != DefaultLockingCollection {
+		t.Fatalf("unexpected collection name: %s", d.cfg.Locking.CollectionName)
+	}
+	if d.cfg.Locking.IndexName != DefaultLockIndexName {
+		t.Fatalf("unexpected index name: %s", d.cfg.Locking.IndexName)
+	}
+	if d.cfg.Locking.HeartbeatInterval != 20*time.Second {
+		t.Fatalf("unexpected heartbeat interval: %v", d.cfg.Locking.HeartbeatInterval)
+	}
+	if d.cfg.Locking.RetryInterval != DefaultLockRetryInterval {
+		t.Fatalf("unexpected retry interval: %v", d.cfg.Locking.RetryInterval)
+	}
+	if d.cfg.Locking.MaxRetryInterval != DefaultLockMaxRetryInterval {
+		t.Fatalf("unexpected max retry interval: %v", d.cfg.Locking.MaxRetryInterval)
+	}
+}
+
 func TestWithLogger(t *testing.T) {
 	d := &driver{}
 
@@ -75,6 +123,24 @@ func TestWithMigrationCollection(t *testing.T) {
 	}
 }
 
+func TestWithStateDatabase(t *testing.T) {
+	d := &driver{cfg: &config{}}
+
+	WithStateDatabase("ops")(d)
+	if d.cfg.StateDatabaseName != "ops" {
+		t.Fatalf("failed to set state database name")
+	}
+}
+
+func TestWithTransactionConfig(t *testing.T) {
+	d := &driver{cfg: &config{}}
+
+	WithTransactionConfig(TransactionConfig{ReadPreference: readpref.Primary()})(d)
+	if d.cfg.Transaction.Timeout != DefaultTransactionTimeout || d.cfg.Transaction.ReadPreference == nil {
+		t.Fatalf("failed to set transaction config")
+	}
+}
+
 func TestWithTransactions(t *testing.T) {
 	d := &driver{cfg: &config{}}
 
@@ -84,6 +150,29 @@ func TestWithTransactions(t *testing.T) {
 	}
 }
 
+func TestWithContext(t *testing.T) {
+	d := &driver{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	WithContext(ctx)(d)
+	if d.ctx != ctx {
+		t.Fatalf("failed to set base context")
+	}
+}
+
+func TestWithTimeouts(t *testing.T) {
+	d := &driver{cfg: &config{}}
+
+	WithTimeouts(TimeoutConfig{Migration: time.Minute})(d)
+	if d.cfg.Timeouts.Migration != time.Minute {
+		t.Fatalf("failed to set migration timeout")
+	}
+	if d.cfg.Timeouts.Lock != DefaultLockTimeout {
+		t.Fatalf("unexpected lock timeout: %v", d.cfg.Timeouts.Lock)
+	}
+}
+
 func TestWithVerboseLogging(t *testing.T) {
 	d := &driver{}
 
@@ -93,6 +182,28 @@ func TestWithVerboseLogging(t *testing.T) {
 	}
 }
 
+func Test_driver_operationContext(t *testing.T) {
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	d := &driver{ctx: baseCtx}
+
+	ctx, cancel := d.operationContext(time.Minute)
+	defer cancel()
+	if _, ok := ctx.Deadline(); !ok {
+		t.Fatalf("missing deadline")
+	}
+
+	noTimeoutCtx, noTimeoutCancel := d.operationContext(0)
+	defer noTimeoutCancel()
+	if _, ok := noTimeoutCtx.Deadline(); ok {
+		t.Fatalf("unexpected deadline")
+	}
+
+	cancelBase()
+	if ctx.Err() == nil || noTimeoutCtx.Err() == nil {
+		t.Fatalf("derived contexts not cancelled")
+	}
+}
+
 func Test_driver_Close(t *testing.T) {
 	d := &driver{}
 	err := d.Close()
@@ -194,6 +305,9 @@ func Test_driver_Lock(t *testing.T) {
 		if atomic.LoadInt32(&d.(*driver).reentrantLockFlag) != 1 {
 			t.Fatalf("not locked")
 		}
+		if d.(*driver).lockOwner == "" {
+			t.Fatalf("missing lock owner token")
+		}
 	})
 
 	mt.Run("Error", func(mt *mtest.T) {
@@ -221,6 +335,211 @@ func Test_driver_Lock(t *testing.T) {
 	})
 }
 
+func Test_driver_Lock_Lease(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("TakeOver", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // prepare lock table (index success response)
+
+		d, err := NewDriver(mt.Client, "test", WithLocking(LockingConfig{Enabled: true, LeaseDuration: time.Minute}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{
+			Index:   0,
+			Code:    11000,
+			Message: "duplicate key error",
+		}))
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{
+			{Key: "locking_key", Value: lockKeyUniqueValue},
+			{Key: "hostname", Value: "crashed-host"},
+			{Key: "pid", Value: 1},
+		}}))
+
+		err = d.Lock()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// should be locked
+		if atomic.LoadInt32(&d.(*driver).reentrantLockFlag) != 1 {
+			t.Fatalf("not locked")
+		}
+	})
+
+	mt.Run("NotExpired", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // prepare lock table (index success response)
+
+		d, err := NewDriver(mt.Client, "test", WithLocking(LockingConfig{Enabled: true, LeaseDuration: time.Minute}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{
+			Index:   0,
+			Code:    11000,
+			Message: "duplicate key error",
+		}))
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil})) // no expired lock found
+
+		err = d.Lock()
+		if err != ErrDatabaseLocked {
+			t.Fatalf("expected ErrDatabaseLocked error, got: %v", err)
+		}
+
+		// should not be locked
+		if atomic.LoadInt32(&d.(*driver).reentrantLockFlag) != 0 {
+			t.Fatalf("unexpected lock")
+		}
+	})
+}
+
+func Test_driver_Lock_Heartbeat(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Close", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // prepare lock table (index success response)
+
+		d, err := NewDriver(mt.Client, "test", WithLocking(LockingConfig{Enabled: true, LeaseDuration: time.Minute}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		err = d.Lock()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if d.(*driver).heartbeatCancel == nil {
+			t.Fatalf("heartbeat not started")
+		}
+
+		err = d.Close()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if d.(*driver).heartbeatCancel != nil {
+			t.Fatalf("heartbeat not stopped")
+		}
+	})
+
+	mt.Run("LockLost", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // prepare lock table (index success response)
+
+		d, err := NewDriver(mt.Client, "test", WithLocking(LockingConfig{
+			Enabled:           true,
+			LeaseDuration:     time.Minute,
+			HeartbeatInterval: 10 * time.Millisecond,
+		}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 0}, {Key: "nModified", Value: 0}}) // renewal: no lock matched
+
+		err = d.Lock()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		select {
+		case <-d.(*driver).lockLostChannel():
+		case <-time.After(time.Second):
+			t.Fatalf("lock loss not detected")
+		}
+
+		err = d.RunMigration(bytes.NewReader([]byte(`[{"ping": 1}]`)))
+		if err != ErrLockLost {
+			t.Fatalf("expected ErrLockLost error, got: %v", err)
+		}
+
+		err = d.Unlock()
+		if err != ErrLockLost {
+			t.Fatalf("expected ErrLockLost error, got: %v", err)
+		}
+
+		// should not be locked
+		if atomic.LoadInt32(&d.(*driver).reentrantLockFlag) != 0 {
+			t.Fatalf("unexpected lock")
+		}
+	})
+}
+
+func Test_driver_Lock_Wait(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	duplicateKeyResponse := mtest.CreateWriteErrorsResponse(mtest.WriteError{
+		Index:   0,
+		Code:    11000,
+		Message: "duplicate key error",
+	})
+	noChangeStreamResponse := mtest.CreateCommandErrorResponse(mtest.CommandError{
+		Message: "The $changeStream stage is only supported on replica sets",
+		Code:    40573,
+	})
+
+	mt.Run("Success", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // prepare lock table (index success response)
+
+		d, err := NewDriver(mt.Client, "test", WithLocking(LockingConfig{
+			Enabled:       true,
+			WaitTimeout:   time.Second,
+			RetryInterval: 10 * time.Millisecond,
+		}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(duplicateKeyResponse, noChangeStreamResponse, duplicateKeyResponse)
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		err = d.Lock()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// should be locked
+		if atomic.LoadInt32(&d.(*driver).reentrantLockFlag) != 1 {
+			t.Fatalf("not locked")
+		}
+	})
+
+	mt.Run("Timeout", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // prepare lock table (index success response)
+
+		d, err := NewDriver(mt.Client, "test", WithLocking(LockingConfig{
+			Enabled:          true,
+			WaitTimeout:      50 * time.Millisecond,
+			RetryInterval:    10 * time.Millisecond,
+			MaxRetryInterval: 20 * time.Millisecond,
+		}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(duplicateKeyResponse, noChangeStreamResponse)
+		for i := 0; i < 5; i++ {
+			mt.AddMockResponses(duplicateKeyResponse)
+		}
+
+		err = d.Lock()
+		if err != ErrDatabaseLocked {
+			t.Fatalf("expected ErrDatabaseLocked error, got: %v", err)
+		}
+
+		// should not be locked
+		if atomic.LoadInt32(&d.(*driver).reentrantLockFlag) != 0 {
+			t.Fatalf("unexpected lock")
+		}
+	})
+}
+
 func Test_driver_Lock_Disabled(t *testing.T) {
 	d := driver{cfg: &config{}}
 	err := d.Lock()
@@ -247,6 +566,149 @@ func Test_driver_Lock_AlreadyLocked(t *testing.T) {
 	}
 }
 
+func Test_driver_LockInfo(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Locked", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // prepare lock table (index success response)
+
+		d, err := NewDriver(mt.Client, "test", WithLocking(LockingConfig{Enabled: true}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(1, "test.migrate_advisory_lock", mtest.FirstBatch, bson.D{
+			{Key: "locking_key", Value: lockKeyUniqueValue},
+			{Key: "owner", Value: "token"},
+			{Key: "hostname", Value: "host"},
+			{Key: "pid", Value: 42},
+		}))
+
+		info, err := d.LockInfo(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if info == nil || info.Owner != "token" || info.Hostname != "host" || info.Pid != 42 {
+			t.Fatalf("unexpected lock info: %+v", info)
+		}
+	})
+
+	mt.Run("NotLocked", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // prepare lock table (index success response)
+
+		d, err := NewDriver(mt.Client, "test", WithLocking(LockingConfig{Enabled: true}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "test.migrate_advisory_lock", mtest.FirstBatch))
+
+		info, err := d.LockInfo(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if info != nil {
+			t.Fatalf("unexpected lock info: %+v", info)
+		}
+	})
+
+	mt.Run("Error", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // prepare lock table (index success response)
+
+		d, err := NewDriver(mt.Client, "test", WithLocking(LockingConfig{Enabled: true}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{
+			Message: "Something is wrong",
+			Code:    666,
+		}))
+
+		_, err = d.LockInfo(context.Background())
+		if err == nil {
+			t.Fatalf("expected error, got: %v", err)
+		}
+	})
+}
+
+func Test_driver_ForceUnlock(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Success", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // prepare lock table (index success response)
+
+		d, err := NewDriver(mt.Client, "test", WithLocking(LockingConfig{Enabled: true}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{
+			{Key: "locking_key", Value: lockKeyUniqueValue},
+			{Key: "hostname", Value: "host"},
+			{Key: "pid", Value: 42},
+		}}))
+
+		err = d.ForceUnlock(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	mt.Run("NotLocked", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // prepare lock table (index success response)
+
+		d, err := NewDriver(mt.Client, "test", WithLocking(LockingConfig{Enabled: true}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}))
+
+		err = d.ForceUnlock(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	mt.Run("Error", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // prepare lock table (index success response)
+
+		d, err := NewDriver(mt.Client, "test", WithLocking(LockingConfig{Enabled: true}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{
+			Message: "Something is wrong",
+			Code:    666,
+		}))
+
+		err = d.ForceUnlock(context.Background())
+		if err == nil {
+			t.Fatalf("expected error, got: %v", err)
+		}
+	})
+}
+
+func Test_driver_LockInfo_Disabled(t *testing.T) {
+	d := driver{cfg: &config{}}
+	info, err := d.LockInfo(context.Background())
+	if err != ErrLockingDisabled {
+		t.Fatalf("expected ErrLockingDisabled, got: %v", err)
+	}
+	if info != nil {
+		t.Fatalf("unexpected lock info: %+v", info)
+	}
+
+	err = d.ForceUnlock(context.Background())
+	if err != ErrLockingDisabled {
+		t.Fatalf("expected ErrLockingDisabled, got: %v", err)
+	}
+}
+
 func Test_driver_Reset(t *testing.T) {
 	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
 	defer mt.Close()
@@ -292,7 +754,7 @@ func Test_driver_RunMigration(t *testing.T) {
 
 		mt.AddMockResponses(mtest.CreateSuccessResponse())
 
-		err = d.(*driver).RunMigration(bytes.NewReader([]byte("[{}]")))
+		err = d.(*driver).RunMigration(bytes.NewReader([]byte(`[{"ping": 1}]`)))
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -306,7 +768,7 @@ func Test_driver_RunMigration(t *testing.T) {
 
 		mt.AddMockResponses(bson.D{{Key: "ok", Value: 0}})
 
-		err = d.(*driver).RunMigration(bytes.NewReader([]byte("[{}]")))
+		err = d.(*driver).RunMigration(bytes.NewReader([]byte(`[{"ping": 1}]`)))
 		if err == nil {
 			t.Fatalf("expected error, got: %v", err)
 		}
@@ -321,7 +783,7 @@ func Test_driver_RunMigration(t *testing.T) {
 		mt.AddMockResponses(mtest.CreateSuccessResponse())
 		mt.AddMockResponses(mtest.CreateSuccessResponse()) // Commit
 
-		err = d.(*driver).RunMigration(bytes.NewReader([]byte("[{}]")))
+		err = d.(*driver).RunMigration(bytes.NewReader([]byte(`[{"insert": "users", "documents": [{"a": 1}]}]`)))
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -335,7 +797,7 @@ func Test_driver_RunMigration(t *testing.T) {
 
 		mt.AddMockResponses(bson.D{{Key: "ok", Value: 0}})
 
-		err = d.(*driver).RunMigration(bytes.NewReader([]byte("[{}]")))
+		err = d.(*driver).RunMigration(bytes.NewReader([]byte(`[{"insert": "users", "documents": [{"a": 1}]}]`)))
 		if err == nil {
 			t.Fatalf("expected error, got: %v", err)
 		}
@@ -352,16 +814,23 @@ func Test_driver_SetVersion(t *testing.T) {
 			t.Fatalf("unexpected error: %v", err)
 		}
 
-		mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "acknowledged", Value: true}, {Key: "n", Value: 1}})
-		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 1}, {Key: "nModified", Value: 1}})
 
 		err = d.SetVersion(5, false)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
+
+		started := mt.GetStartedEvent()
+		if started == nil || started.CommandName != "update" {
+			t.Fatalf("expected a single update command, got: %v", started)
+		}
+		if mt.GetStartedEvent() != nil {
+			t.Fatalf("unexpected additional command")
+		}
 	})
 
-	mt.Run("DropError", func(mt *mtest.T) {
+	mt.Run("Error", func(mt *mtest.T) {
 		d, err := NewDriver(mt.Client, "test")
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
@@ -378,16 +847,16 @@ func Test_driver_SetVersion(t *testing.T) {
 		}
 	})
 
-	mt.Run("InsertError", func(mt *mtest.T) {
+	mt.Run("WriteError", func(mt *mtest.T) {
 		d, err := NewDriver(mt.Client, "test")
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
 
-		mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "acknowledged", Value: true}, {Key: "n", Value: 1}})
-		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{
-			Message: "Something is wrong",
-			Code:    666,
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{
+			Index:   0,
+			Code:    121,
+			Message: "Document failed validation",
 		}))
 
 		err = d.SetVersion(5, false)
@@ -423,6 +892,29 @@ func Test_driver_Unlock(t *testing.T) {
 		}
 	})
 
+	mt.Run("CancelledContext", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // prepare lock table (index success response)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		d, err := NewDriver(mt.Client, "test", WithContext(ctx), WithLocking(LockingConfig{Enabled: true}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		d.(*driver).reentrantLockFlag = 1 // simulate locked driver
+		cancel()                          // e.g. SIGTERM
+
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "acknowledged", Value: true}, {Key: "n", Value: 1}}) // n = 1 doc deleted
+
+		err = d.Unlock()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		mt.GetStartedEvent() // prepare lock table
+		if started := mt.GetStartedEvent(); started == nil || started.CommandName != "delete" {
+			t.Fatalf("expected delete, got: %v", started)
+		}
+	})
+
 	mt.Run("Error", func(mt *mtest.T) {
 		mt.AddMockResponses(mtest.CreateSuccessResponse()) // prepare lock table (index success response)
 
@@ -446,6 +938,34 @@ func Test_driver_Unlock(t *testing.T) {
 	})
 }
 
+func Test_driver_Unlock_LockLost(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("NotOwned", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // prepare lock table (index success response)
+
+		d, err := NewDriver(mt.Client, "test", WithLocking(LockingConfig{Enabled: true}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		d.(*driver).reentrantLockFlag = 1 // simulate locked driver
+		d.(*driver).lockOwner = "token"
+
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "acknowledged", Value: true}, {Key: "n", Value: 0}}) // n = 0 docs deleted
+
+		err = d.Unlock()
+		if err != ErrLockLost {
+			t.Fatalf("expected ErrLockLost error, got: %v", err)
+		}
+
+		// should not be locked
+		if atomic.LoadInt32(&d.(*driver).reentrantLockFlag) != 0 {
+			t.Fatalf("unexptected lock")
+		}
+	})
+}
+
 func Test_driver_Unlock_Disabled(t *testing.T) {
 	d := driver{cfg: &config{}}
 	err := d.Unlock()
@@ -485,7 +1005,7 @@ func Test_driver_executeCommands(t *testing.T) {
 		mt.AddMockResponses(mtest.CreateSuccessResponse())
 		mt.AddMockResponses(mtest.CreateSuccessResponse())
 
-		err = d.(*driver).executeCommands(context.Background(), []bson.D{{}, {}})
+		err = d.(*driver).executeCommands(context.Background(), []migrationCommand{{Command: bson.D{}}, {Command: bson.D{}}})
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -500,13 +1020,113 @@ func Test_driver_executeCommands(t *testing.T) {
 		mt.AddMockResponses(mtest.CreateSuccessResponse())
 		mt.AddMockResponses(bson.D{{Key: "ok", Value: 0}}) // second one failed
 
-		err = d.(*driver).executeCommands(context.Background(), []bson.D{{}, {}})
+		err = d.(*driver).executeCommands(context.Background(), []migrationCommand{{Command: bson.D{}}, {Command: bson.D{}}})
 		if err == nil {
 			t.Fatalf("expected error, got: %v", err)
 		}
 	})
 }
 
+func Test_driver_executeCommands_TargetDatabase(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Success", func(mt *mtest.T) {
+		d, err := NewDriver(mt.Client, "test")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		err = d.(*driver).executeCommands(context.Background(), []migrationCommand{
+			{Database: "admin", Command: bson.D{{Key: "ping", Value: 1}}},
+			{Command: bson.D{{Key: "ping", Value: 1}}},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if started := mt.GetStartedEvent(); started.DatabaseName != "admin" {
+			t.Fatalf("unexpected database: %s", started.DatabaseName)
+		}
+		if started := mt.GetStartedEvent(); started.DatabaseName != "test" {
+			t.Fatalf("unexpected database: %s", started.DatabaseName)
+		}
+	})
+}
+
+func Test_driver_StateDatabase(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Success", func(mt *mtest.T) {
+		d, err := NewDriver(mt.Client, "app", WithStateDatabase("ops"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 1}, {Key: "nModified", Value: 1}})
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // migration progress
+
+		if err = d.SetVersion(1, true); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err = d.RunMigration(bytes.NewReader([]byte(`[{"ping": 1}]`))); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if started := mt.GetStartedEvent(); started.DatabaseName != "ops" {
+			t.Fatalf("unexpected state database: %s", started.DatabaseName)
+		}
+		if started := mt.GetStartedEvent(); started.DatabaseName != "app" {
+			t.Fatalf("unexpected command database: %s", started.DatabaseName)
+		}
+		if started := mt.GetStartedEvent(); started.DatabaseName != "ops" {
+			t.Fatalf("unexpected progress database: %s", started.DatabaseName)
+		}
+	})
+
+	mt.Run("SharedStateDatabase", func(mt *mtest.T) {
+		app, err := NewDriver(mt.Client, "app", WithStateDatabase("ops"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		billing, err := NewDriver(mt.Client, "billing", WithStateDatabase("ops"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		appCfg, billingCfg := app.(*driver).cfg, billing.(*driver).cfg
+		if appCfg.MigrationsCollection != "app_schema_migrations" || appCfg.Locking.Scope != "app_schema_migrations" {
+			t.Fatalf("unexpected migrations collection or lock scope: %s, %s",
+				appCfg.MigrationsCollection, appCfg.Locking.Scope)
+		}
+		if billingCfg.MigrationsCollection == appCfg.MigrationsCollection || billingCfg.Locking.Scope == appCfg.Locking.Scope {
+			t.Fatalf("migrated databases share the migration state")
+		}
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // set version
+		if err = app.SetVersion(1, false); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if started := mt.GetStartedEvent(); started.Command.Lookup("update").StringValue() != "app_schema_migrations" {
+			t.Fatalf("unexpected migrations collection: %v", started.Command)
+		}
+	})
+
+	mt.Run("ExplicitMigrationsCollection", func(mt *mtest.T) {
+		d, err := NewDriver(mt.Client, "app", WithStateDatabase("ops"), WithMigrationCollection("app_state"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if d.(*driver).cfg.MigrationsCollection != "app_state" {
+			t.Fatalf("unexpected migrations collection: %s", d.(*driver).cfg.MigrationsCollection)
+		}
+	})
+}
+
 func Test_driver_executeCommandsWithTransaction(t *testing.T) {
 	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
 	defer mt.Close()
@@ -521,7 +1141,7 @@ func Test_driver_executeCommandsWithTransaction(t *testing.T) {
 		mt.AddMockResponses(mtest.CreateSuccessResponse())
 		mt.AddMockResponses(mtest.CreateSuccessResponse()) // commit transaction
 
-		err = d.(*driver).executeCommandsWithTransaction(context.Background(), []bson.D{{}, {}})
+		err = d.(*driver).executeCommandsWithTransaction(context.Background(), []migrationCommand{{Command: bson.D{}}, {Command: bson.D{}}}, nil, true)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -535,7 +1155,7 @@ func Test_driver_executeCommandsWithTransaction(t *testing.T) {
 
 		mt.AddMockResponses(bson.D{{Key: "ok", Value: 0}}) // first command failed
 
-		err = d.(*driver).executeCommandsWithTransaction(context.Background(), []bson.D{{}, {}})
+		err = d.(*driver).executeCommandsWithTransaction(context.Background(), []migrationCommand{{Command: bson.D{}}, {Command: bson.D{}}}, nil, true)
 		if err == nil {
 			t.Fatalf("expected error, got: %v", err)
 		}
@@ -551,12 +1171,146 @@ func Test_driver_executeCommandsWithTransaction(t *testing.T) {
 		mt.AddMockResponses(mtest.CreateSuccessResponse())
 		mt.AddMockResponses(bson.D{{Key: "ok", Value: 0}}) // commit transaction error
 
-		err = d.(*driver).executeCommandsWithTransaction(context.Background(), []bson.D{{}, {}})
+		err = d.(*driver).executeCommandsWithTransaction(context.Background(), []migrationCommand{{Command: bson.D{}}, {Command: bson.D{}}}, nil, true)
 		if err == nil {
 			t.Fatalf("expected error, got: %v", err)
 		}
 	})
 
+	mt.Run("TransientError", func(mt *mtest.T) {
+		d, err := NewDriver(mt.Client, "test")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 0}, {Key: "code", Value: 112}, {Key: "errmsg", Value: "write conflict"},
+			{Key: "errorLabels", Value: bson.A{"TransientTransactionError"}}}) // first attempt failed
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // abort transaction
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 0}, {Key: "code", Value: 64}, {Key: "errmsg", Value: "waiting for replication timed out"},
+			{Key: "errorLabels", Value: bson.A{"UnknownTransactionCommitResult"}}}) // commit result unknown
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // commit transaction retry
+
+		err = d.(*driver).executeCommandsWithTransaction(context.Background(), []migrationCommand{{Command: bson.D{}}}, nil, true)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	mt.Run("Options", func(mt *mtest.T) {
+		d, err := NewDriver(mt.Client, "test", WithTransactionConfig(TransactionConfig{
+			WriteConcern: writeconcern.New(writeconcern.WMajority()),
+			ReadConcern:  readconcern.Snapshot(),
+		}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // commit transaction
+
+		err = d.(*driver).executeCommandsWithTransaction(context.Background(), []migrationCommand{{Command: bson.D{{Key: "ping", Value: 1}}}}, nil, true)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		started := mt.GetStartedEvent()
+		if level, lookupErr := started.Command.LookupErr("readConcern", "level"); lookupErr != nil || level.StringValue() != "snapshot" {
+			t.Fatalf("missing read concern: %v", started.Command)
+		}
+		started = mt.GetStartedEvent()
+		if w, lookupErr := started.Command.LookupErr("writeConcern", "w"); lookupErr != nil || w.StringValue() != "majority" {
+			t.Fatalf("missing write concern: %v", started.Command)
+		}
+	})
+
+}
+
+func Test_driver_RunMigration_VersionInTransaction(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Success", func(mt *mtest.T) {
+		d, err := NewDriver(mt.Client, "test", WithTransactions(true),
+			WithTransactionConfig(TransactionConfig{IncludeVersion: true}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // set dirty version
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // migration command
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // migration progress
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // set clean version
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // commit transaction
+
+		if err = d.SetVersion(1, true); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err = d.RunMigration(bytes.NewReader([]byte(`[{"insert": "users", "documents": [{"a": 1}]}]`))); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err = d.SetVersion(1, false); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.GetStartedEvent() // set dirty version
+		if started := mt.GetStartedEvent(); started.CommandName != "insert" {
+			t.Fatalf("unexpected command: %s", started.CommandName)
+		}
+		mt.GetStartedEvent() // migration progress
+		started := mt.GetStartedEvent()
+		if _, lookupErr := started.Command.LookupErr("txnNumber"); lookupErr != nil || started.CommandName != "update" {
+			t.Fatalf("expected version update within transaction, got: %v", started.Command)
+		}
+		if dirty, lookupErr := started.Command.LookupErr("updates", "0", "u", "$set", "dirty"); lookupErr != nil || dirty.Boolean() {
+			t.Fatalf("expected clean version, got: %v", started.Command)
+		}
+		if started = mt.GetStartedEvent(); started.CommandName != "commitTransaction" {
+			t.Fatalf("expected commit, got: %s", started.CommandName)
+		}
+		if started = mt.GetStartedEvent(); started != nil {
+			t.Fatalf("unexpected command after commit: %v", started.Command)
+		}
+	})
+
+	mt.Run("Disabled", func(mt *mtest.T) {
+		d, err := NewDriver(mt.Client, "test", WithTransactions(true))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // set dirty version
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // migration command
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // migration progress
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // commit transaction
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // set clean version
+
+		if err = d.SetVersion(1, true); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err = d.RunMigration(bytes.NewReader([]byte(`[{"insert": "users", "documents": [{"a": 1}]}]`))); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err = d.SetVersion(1, false); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.GetStartedEvent() // set dirty version
+		mt.GetStartedEvent() // migration command
+		started := mt.GetStartedEvent()
+		if _, lookupErr := started.Command.LookupErr("txnNumber"); lookupErr != nil || started.CommandName != "update" {
+			t.Fatalf("expected progress update within transaction, got: %v", started.Command)
+		}
+		if committed, lookupErr := started.Command.LookupErr("updates", "0", "u", "$set", "progress.committed"); lookupErr != nil || !committed.Boolean() {
+			t.Fatalf("expected committed progress, got: %v", started.Command)
+		}
+		if started := mt.GetStartedEvent(); started.CommandName != "commitTransaction" {
+			t.Fatalf("expected commit, got: %s", started.CommandName)
+		}
+		if started := mt.GetStartedEvent(); started == nil || started.CommandName != "update" {
+			t.Fatalf("expected version update after commit, got: %v", started)
+		}
+	})
 }
 
 func Test_driver_prepareLockCollection(t *testing.T) {
@@ -590,4 +1344,85 @@ func Test_driver_prepareLockCollection(t *testing.T) {
 			t.Fatalf("expected error, got: %v", err)
 		}
 	})
+
+	mt.Run("ScopedIndex", func(mt *mtest.T) {
+		for _, legacy := range []bool{false, true} {
+			d, err := NewDriver(mt.Client, "test", WithLocking(LockingConfig{LegacyCompatibility: legacy}))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+			err = d.(*driver).prepareLockCollection()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			started := mt.GetStartedEvent()
+			if started == nil || started.CommandName != "createIndexes" {
+				t.Fatalf("expected createIndexes, got: %v", started)
+			}
+			_, lookupErr := started.Command.LookupErr("indexes", "0", "key", "scope")
+			if scoped := lookupErr == nil; scoped == legacy {
+				t.Fatalf("unexpected index specification (legacy %t): %v", legacy, started.Command)
+			}
+		}
+	})
+
+	mt.Run("ConflictingIndex", func(mt *mtest.T) {
+		d, err := NewDriver(mt.Client, "test") // legacy index exists
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{
+			Message: "An existing index has the same name as the requested index",
+			Code:    86,
+		}))
+
+		err = d.(*driver).prepareLockCollection()
+		if !isIndexConflictError(err) {
+			t.Fatalf("expected index conflict error, got: %v", err)
+		}
+		if started := mt.GetStartedEvent(); started == nil || started.CommandName != "createIndexes" {
+			t.Fatalf("expected createIndexes, got: %v", started)
+		}
+		if started := mt.GetStartedEvent(); started != nil {
+			t.Fatalf("expected the existing index to be kept, got: %s", started.CommandName)
+		}
+	})
+}
+
+func Test_driver_lockFilter(t *testing.T) {
+	d := &driver{cfg: &config{Locking: LockingConfig{Scope: DefaultMigrationsCollection, LegacyCompatibility: true}}}
+	filter := d.lockFilter("token")
+	scope, ok := filter["scope"].(bson.M)
+	if !ok || len(scope["$in"].(bson.A)) != 2 || filter["owner"] != "token" {
+		t.Fatalf("unexpected filter: %v", filter)
+	}
+
+	d.cfg.Locking.LegacyCompatibility = false
+	filter = d.lockFilter("")
+	if filter["scope"] != DefaultMigrationsCollection {
+		t.Fatalf("unexpected filter: %v", filter)
+	}
+	if _, ok := filter["owner"]; ok {
+		t.Fatalf("unexpected owner filter: %v", filter)
+	}
+}
+
+func Test_newOwnerToken(t *testing.T) {
+	token, err := newOwnerToken()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(token) != 36 || token[14] != '4' {
+		t.Fatalf("unexpected token format: %s", token)
+	}
+
+	other, _ := newOwnerToken()
+	if token == other {
+		t.Fatalf("tokens are not unique")
+	}
 }
@@ -0,0 +1,96 @@
+package mongodb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/h44z/lightmigrate"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// progressField is the field of the version document that holds the progress of the in-flight migration.
+const progressField = "progress"
+
+// migrationProgress is stored in the version document while a migration is applied or reverted.
+type migrationProgress struct {
+	Version     int64                  `bson:"version"`      // version of the in-flight migration
+	Direction   lightmigrate.Direction `bson:"direction"`    // direction of the in-flight migration
+	BaseVersion int64                  `bson:"base_version"` // version that was applied before the migration started
+	Checksum    string                 `bson:"checksum"`     // checksum of the migration contents, set by the first checkpoint
+	Completed   int                    `bson:"completed"`    // number of commands that were executed successfully
+	Committed   bool                   `bson:"committed"`    // set once the final migration transaction was committed
+}
+
+// matches checks if the progress belongs to the given migration.
+func (p *migrationProgress) matches(pending *pendingMigration) bool {
+	return p != nil && pending != nil && uint64(p.Version) == pending.Version && p.Direction == pending.Direction
+}
+
+// resumeOffset returns the number of commands that can be skipped because they have already been executed
+// by an interrupted run of the pending migration. Resuming is refused if the migration has changed since.
+func (d *driver) resumeOffset(sum string, commands int) (int, error) {
+	if !d.cfg.ResumeMode || !d.progress.matches(d.pending) || (d.progress.Completed == 0 && !d.progress.Committed) {
+		return 0, nil
+	}
+
+	if d.progress.Checksum != "" && d.progress.Checksum != sum {
+		return 0, fmt.Errorf("unable to resume migration %d: contents changed since it was interrupted",
+			d.pending.Version)
+	}
+	if d.progress.Completed > commands {
+		return 0, fmt.Errorf("unable to resume migration %d: %d commands completed, but only %d exist",
+			d.pending.Version, d.progress.Completed, commands)
+	}
+
+	if d.progress.Committed {
+		d.logMessage("migration %d (%s) was committed before it was interrupted, skipping it",
+			d.pending.Version, d.pending.Direction)
+	} else {
+		d.logMessage("resuming migration %d (%s), skipping %d of %d command(s)",
+			d.pending.Version, d.pending.Direction, d.progress.Completed, commands)
+	}
+	return d.progress.Completed, nil
+}
+
+// resumesCommitted checks if the pending migration is resumed after its final transaction was committed, so that
+// neither its commands nor its go migration must be run again.
+func (d *driver) resumesCommitted() bool {
+	return d.cfg.ResumeMode && d.progress.matches(d.pending) && d.progress.Committed
+}
+
+// checkpoint records that one more command of the in-flight migration has been executed successfully.
+// Within transactions no checkpoints are stored, as either all or none of the commands take effect. Segments of
+// split migrations record their progress within their own transaction, see executeSegmentWithTransaction.
+func (d *driver) checkpoint(ctx context.Context) error {
+	if d.progress == nil || mongo.SessionFromContext(ctx) != nil {
+		return nil
+	}
+
+	completed := d.progress.Completed + 1
+	if err := d.saveProgress(ctx, completed, false); err != nil {
+		return err
+	}
+	d.progress.Completed = completed
+	return nil
+}
+
+// saveProgress stores the number of successfully executed commands of the in-flight migration. The committed flag
+// marks that the final migration transaction (including the go migration) is committed along with the progress.
+func (d *driver) saveProgress(ctx context.Context, completed int, committed bool) error {
+	if d.progress == nil {
+		return nil
+	}
+
+	update := bson.M{"$set": bson.M{
+		progressField + ".completed": completed,
+		progressField + ".committed": committed,
+		progressField + ".checksum":  d.progress.Checksum,
+	}}
+	_, err := d.migDb.Collection(d.cfg.MigrationsCollection).UpdateOne(ctx, bson.M{}, update)
+	if err != nil {
+		return &lightmigrate.DriverError{OrigErr: err, Msg: "failed to save migration progress"}
+	}
+	d.logVerbose("saved migration progress: %d command(s) completed", completed)
+	return nil
+}
@@ -0,0 +1,218 @@
+package mongodb
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/h44z/lightmigrate"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestWithResume(t *testing.T) {
+	d := &driver{cfg: &config{}}
+
+	WithResume(true)(d)
+	if !d.cfg.ResumeMode {
+		t.Fatalf("failed to set resume mode")
+	}
+}
+
+func Test_driver_resumeOffset(t *testing.T) {
+	sum := checksum([]byte(`[{"ping": 1}, {"ping": 1}]`))
+	pending := &pendingMigration{Version: 2, Direction: lightmigrate.Up}
+
+	tests := []struct {
+		name     string
+		resume   bool
+		progress *migrationProgress
+		want     int
+		wantErr  bool
+	}{
+		{name: "Disabled", resume: false, progress: &migrationProgress{Version: 2, Direction: lightmigrate.Up, Completed: 1}, want: 0},
+		{name: "NoProgress", resume: true, want: 0},
+		{name: "OtherVersion", resume: true, progress: &migrationProgress{Version: 3, Direction: lightmigrate.Up, Completed: 1}, want: 0},
+		{name: "OtherDirection", resume: true, progress: &migrationProgress{Version: 2, Direction: lightmigrate.Down, Completed: 1}, want: 0},
+		{name: "Resume", resume: true, progress: &migrationProgress{Version: 2, Direction: lightmigrate.Up, Checksum: sum, Completed: 1}, want: 1},
+		{name: "Changed", resume: true, progress: &migrationProgress{Version: 2, Direction: lightmigrate.Up, Checksum: "other", Completed: 1}, wantErr: true},
+		{name: "TooManyCompleted", resume: true, progress: &migrationProgress{Version: 2, Direction: lightmigrate.Up, Completed: 3}, wantErr: true},
+		{name: "Committed", resume: true, progress: &migrationProgress{Version: 2, Direction: lightmigrate.Up, Checksum: sum, Completed: 2, Committed: true}, want: 2},
+		{name: "CommittedChanged", resume: true, progress: &migrationProgress{Version: 2, Direction: lightmigrate.Up, Checksum: "other", Committed: true}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &driver{cfg: &config{ResumeMode: tt.resume}, pending: pending, progress: tt.progress, logger: &testLogger{}}
+			got, err := d.resumeOffset(sum, 2)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("unexpected offset: %d", got)
+			}
+		})
+	}
+}
+
+func Test_driver_GetVersion_Resume(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Success", func(mt *mtest.T) {
+		d, err := NewDriver(mt.Client, "test", WithResume(true), WithLogger(&testLogger{}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(1, "test.schema_migrations", mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: primitive.NewObjectID()},
+			{Key: "version", Value: int64(2)},
+			{Key: "dirty", Value: true},
+			{Key: "progress", Value: bson.D{
+				{Key: "version", Value: int64(2)},
+				{Key: "direction", Value: "up"},
+				{Key: "base_version", Value: int64(1)},
+				{Key: "completed", Value: 1},
+			}},
+		}))
+		version, dirty, err := d.GetVersion()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if version != 1 || dirty {
+			t.Fatalf("unexpected version: %d (dirty: %t)", version, dirty)
+		}
+		if p := d.(*driver).progress; p == nil || p.Completed != 1 {
+			t.Fatalf("unexpected progress: %v", p)
+		}
+	})
+
+	mt.Run("Disabled", func(mt *mtest.T) {
+		d, err := NewDriver(mt.Client, "test")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(1, "test.schema_migrations", mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: primitive.NewObjectID()},
+			{Key: "version", Value: int64(2)},
+			{Key: "dirty", Value: true},
+			{Key: "progress", Value: bson.D{{Key: "version", Value: int64(2)}, {Key: "completed", Value: 1}}},
+		}))
+		version, dirty, err := d.GetVersion()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if version != 2 || !dirty {
+			t.Fatalf("unexpected version: %d (dirty: %t)", version, dirty)
+		}
+	})
+}
+
+func Test_driver_RunMigration_Resume(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Success", func(mt *mtest.T) {
+		migr := []byte(`[{"create": "users"}, {"ping": 1}]`)
+		var results []CommandResult
+		d, err := NewDriver(mt.Client, "test", WithResume(true), WithLogger(&testLogger{}),
+			WithCommandResultHook(func(result CommandResult) { results = append(results, result) }))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		d.(*driver).appliedVersion = 1
+		d.(*driver).progress = &migrationProgress{Version: 2, Direction: lightmigrate.Up, BaseVersion: 1,
+			Checksum: checksum(migr), Completed: 1}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // set dirty version
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // migration command
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // migration progress
+
+		if err = d.SetVersion(2, true); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err = d.RunMigration(bytes.NewReader(migr)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		started := mt.GetStartedEvent()
+		if _, lookupErr := started.Command.LookupErr("updates", "0", "u", "$set", "progress"); lookupErr == nil {
+			t.Fatalf("progress of resumed migration was reset: %v", started.Command)
+		}
+		if started = mt.GetStartedEvent(); started.CommandName != "ping" {
+			t.Fatalf("expected completed commands to be skipped, got: %s", started.CommandName)
+		}
+		started = mt.GetStartedEvent()
+		completed, lookupErr := started.Command.LookupErr("updates", "0", "u", "$set", "progress.completed")
+		if lookupErr != nil || completed.Int32() != 2 {
+			t.Fatalf("unexpected progress update: %v", started.Command)
+		}
+		if len(results) != 1 || results[0].Index != 1 {
+			t.Fatalf("expected result of the second command of the migration file, got: %+v", results)
+		}
+	})
+
+	mt.Run("Committed", func(mt *mtest.T) {
+		migr := []byte(`[{"insert": "users", "documents": [{"a": 1}]}]`)
+		called := false
+		d, err := NewDriver(mt.Client, "test", WithResume(true), WithTransactions(true), WithLogger(&testLogger{}),
+			WithGoMigrations(MigrationRegistry{2: {Up: func(ctx context.Context, db *mongo.Database) error {
+				called = true
+				return nil
+			}}}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		d.(*driver).appliedVersion = 1
+		d.(*driver).progress = &migrationProgress{Version: 2, Direction: lightmigrate.Up, BaseVersion: 1,
+			Checksum: checksum(migr), Completed: 1, Committed: true}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // set dirty version
+
+		if err = d.SetVersion(2, true); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err = d.RunMigration(bytes.NewReader(migr)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.GetStartedEvent() // set dirty version
+		if started := mt.GetStartedEvent(); started != nil {
+			t.Fatalf("expected committed migration to be skipped, got: %s", started.CommandName)
+		}
+		if called {
+			t.Fatalf("expected committed go migration to be skipped")
+		}
+	})
+
+	mt.Run("Failure", func(mt *mtest.T) {
+		d, err := NewDriver(mt.Client, "test")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // set dirty version
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // first migration command
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // migration progress
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 0}}) // second migration command
+
+		if err = d.SetVersion(1, true); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err = d.RunMigration(bytes.NewReader([]byte(`[{"ping": 1}, {"ping": 1}]`))); err == nil {
+			t.Fatalf("expected error, got: %v", err)
+		}
+
+		started := mt.GetStartedEvent()
+		if _, lookupErr := started.Command.LookupErr("updates", "0", "u", "$set", "progress"); lookupErr != nil {
+			t.Fatalf("progress not initialized: %v", started.Command)
+		}
+		if p := d.(*driver).progress; p == nil || p.Completed != 1 {
+			t.Fatalf("unexpected progress: %v", p)
+		}
+	})
+}
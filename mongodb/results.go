@@ -0,0 +1,193 @@
+package mongodb
+
+import (
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type replyWriteError struct {
+	Index   int      `bson:"index"`
+	Code    int      `bson:"code"`
+	Message string   `bson:"errmsg"`
+	Details bson.Raw `bson:"errInfo,omitempty"`
+}
+
+type replyWriteConcernError struct {
+	Name    string   `bson:"codeName"`
+	Code    int      `bson:"code"`
+	Message string   `bson:"errmsg"`
+	Details bson.Raw `bson:"errInfo,omitempty"`
+}
+
+type replyErrors struct {
+	WriteErrors       []replyWriteError       `bson:"writeErrors,omitempty"`
+	WriteConcernError *replyWriteConcernError `bson:"writeConcernError,omitempty"`
+	Labels            []string                `bson:"errorLabels,omitempty"`
+}
+
+// CommandResult describes the outcome of a single command of a migration.
+type CommandResult struct {
+	// Index is the position of the command within the migration (starting at 0).
+	Index int
+	// Database is the name of the database the command was executed on.
+	Database string
+	// Command is the name of the command, e.g. update or createIndexes.
+	Command string
+	// Collection is the target collection of the command. It is empty for commands that do not target a collection.
+	Collection string
+	// N is the number of documents affected by the command (inserted, matched or deleted documents).
+	N int64
+	// Modified is the number of documents modified by an update command.
+	Modified int64
+	// Upserted is the number of documents upserted by an update command.
+	Upserted int64
+	// IndexesBefore is the number of indexes before a createIndexes command was executed.
+	IndexesBefore int64
+	// IndexesAfter is the number of indexes after a createIndexes command was executed.
+	IndexesAfter int64
+	// Duration is the execution time of the command.
+	Duration time.Duration
+	// Reply is the raw reply of the database server, it is nil if the command failed.
+	Reply bson.Raw
+	// Err is the error returned by the command, nil if the command was successful.
+	Err error
+}
+
+// CommandResultHook is a function that gets called after each executed migration command.
+type CommandResultHook func(result CommandResult)
+
+// newCommandResult builds the CommandResult of a single command from the raw server reply.
+func newCommandResult(index int, database string, cmd bson.D, reply bson.Raw, duration time.Duration,
+	err error) CommandResult {
+	result := CommandResult{
+		Index:    index,
+		Database: database,
+		Duration: duration,
+		Err:      err,
+	}
+	result.Command = commandName(cmd)
+	if _, ok := collectionCommands[result.Command]; ok {
+		result.Collection, _ = cmd[0].Value.(string)
+	}
+	if err != nil || reply == nil {
+		return result
+	}
+
+	result.Reply = reply
+	result.N = lookupInt(reply, "n")
+	result.Modified = lookupInt(reply, "nModified")
+	result.IndexesBefore = lookupInt(reply, "numIndexesBefore")
+	result.IndexesAfter = lookupInt(reply, "numIndexesAfter")
+	if upserted, lookupErr := reply.LookupErr("upserted"); lookupErr == nil {
+		if values, arrErr := upserted.Array().Values(); arrErr == nil {
+			result.Upserted = int64(len(values))
+		}
+	}
+	if result.Command == "findAndModify" || result.Command == "findandmodify" {
+		result.N = lookupInt(reply, "lastErrorObject", "n")
+	}
+
+	return result
+}
+
+// collectionCommands contains the names (and the aliases registered by the server) of all commands whose first value
+// is the name of the target collection.
+// The first value of other commands is e.g. a user name (createUser) or a namespace (renameCollection).
+var collectionCommands = map[string]struct{}{
+	"aggregate":               {},
+	"cloneCollectionAsCapped": {},
+	"collMod":                 {},
+	"collStats":               {},
+	"compact":                 {},
+	"convertToCapped":         {},
+	"count":                   {},
+	"create":                  {},
+	"createIndexes":           {},
+	"createSearchIndexes":     {},
+	"delete":                  {},
+	"distinct":                {},
+	"drop":                    {},
+	"dropIndexes":             {},
+	"dropSearchIndex":         {},
+	"find":                    {},
+	"findAndModify":           {},
+	"findandmodify":           {},
+	"insert":                  {},
+	"listIndexes":             {},
+	"mapReduce":               {},
+	"mapreduce":               {},
+	"reIndex":                 {},
+	"update":                  {},
+	"updateSearchIndex":       {},
+	"validate":                {},
+}
+
+// commandName returns the name of the command, which is the key of the first element of the command document.
+func commandName(cmd bson.D) string {
+	if len(cmd) == 0 {
+		return ""
+	}
+	return cmd[0].Key
+}
+
+// lookupInt returns the numeric value at the given path of the document, or 0 if the value does not exist.
+func lookupInt(doc bson.Raw, path ...string) int64 {
+	value, err := doc.LookupErr(path...)
+	if err != nil {
+		return 0
+	}
+
+	n, _ := value.AsInt64OK() // int32, int64 and double values are supported
+	return n
+}
+
+// asCommandWriteError converts write exceptions, returned by the MongoDB client for some command replies,
+// to a CommandWriteError. All other errors are returned unchanged.
+func asCommandWriteError(command string, err error) error {
+	var writeException mongo.WriteException
+	if !errors.As(err, &writeException) {
+		return err
+	}
+
+	return CommandWriteError{
+		Command:           command,
+		WriteErrors:       writeException.WriteErrors,
+		WriteConcernError: writeException.WriteConcernError,
+		Labels:            writeException.Labels,
+	}
+}
+
+// writeErrorsFromReply checks the command reply for write errors and write concern errors. If there are any,
+// a CommandWriteError is returned.
+func writeErrorsFromReply(command string, reply bson.Raw) error {
+	var errs replyErrors
+	if err := bson.Unmarshal(reply, &errs); err != nil {
+		return err
+	}
+	if len(errs.WriteErrors) == 0 && errs.WriteConcernError == nil {
+		return nil
+	}
+
+	writeErr := CommandWriteError{Command: command, Labels: errs.Labels}
+	for _, we := range errs.WriteErrors {
+		writeErr.WriteErrors = append(writeErr.WriteErrors, mongo.WriteError{
+			Index:   we.Index,
+			Code:    we.Code,
+			Message: we.Message,
+			Details: we.Details,
+		})
+	}
+	if wce := errs.WriteConcernError; wce != nil {
+		writeErr.WriteConcernError = &mongo.WriteConcernError{
+			Name:    wce.Name,
+			Code:    wce.Code,
+			Message: wce.Message,
+			Details: wce.Details,
+		}
+	}
+
+	return writeErr
+}
@@ -0,0 +1,223 @@
+package mongodb
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestWithCommandResultHook(t *testing.T) {
+	d := &driver{}
+
+	WithCommandResultHook(func(result CommandResult) {})(d)
+	if d.resultHook == nil {
+		t.Fatalf("failed to set command result hook")
+	}
+}
+
+func Test_newCommandResult(t *testing.T) {
+	cmd := bson.D{{Key: "update", Value: "users"}, {Key: "updates", Value: bson.A{}}}
+	reply, err := bson.Marshal(bson.D{
+		{Key: "ok", Value: 1},
+		{Key: "n", Value: int32(5)},
+		{Key: "nModified", Value: int32(3)},
+		{Key: "upserted", Value: bson.A{bson.D{{Key: "index", Value: 0}}}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	result := newCommandResult(2, "test", cmd, reply, time.Second, nil)
+	if result.Index != 2 || result.Database != "test" || result.Duration != time.Second {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	if result.Command != "update" || result.Collection != "users" {
+		t.Fatalf("unexpected command or collection: %+v", result)
+	}
+	if result.N != 5 || result.Modified != 3 || result.Upserted != 1 {
+		t.Fatalf("unexpected counts: %+v", result)
+	}
+}
+
+func Test_newCommandResult_Collection(t *testing.T) {
+	tests := []struct {
+		cmd        bson.D
+		collection string
+	}{
+		{cmd: bson.D{{Key: "createIndexes", Value: "users"}}, collection: "users"},
+		{cmd: bson.D{{Key: "findAndModify", Value: "users"}}, collection: "users"},
+		{cmd: bson.D{{Key: "findandmodify", Value: "users"}}, collection: "users"},
+		{cmd: bson.D{{Key: "aggregate", Value: 1}}, collection: ""},
+		{cmd: bson.D{{Key: "createUser", Value: "app"}}, collection: ""},
+		{cmd: bson.D{{Key: "dropUser", Value: "app"}}, collection: ""},
+		{cmd: bson.D{{Key: "renameCollection", Value: "app.users"}, {Key: "to", Value: "app.accounts"}}, collection: ""},
+		{cmd: bson.D{}, collection: ""},
+	}
+
+	for _, tt := range tests {
+		if result := newCommandResult(0, "test", tt.cmd, nil, 0, nil); result.Collection != tt.collection {
+			t.Errorf("collection of %v = %q, want %q", tt.cmd, result.Collection, tt.collection)
+		}
+	}
+}
+
+func Test_newCommandResult_FindAndModify(t *testing.T) {
+	reply, err := bson.Marshal(bson.D{
+		{Key: "ok", Value: 1},
+		{Key: "lastErrorObject", Value: bson.D{{Key: "n", Value: int32(1)}, {Key: "updatedExisting", Value: true}}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, name := range []string{"findAndModify", "findandmodify"} {
+		result := newCommandResult(0, "test", bson.D{{Key: name, Value: "users"}}, reply, 0, nil)
+		if result.N != 1 || result.Collection != "users" {
+			t.Fatalf("unexpected result of %s: %+v", name, result)
+		}
+	}
+}
+
+func Test_newCommandResult_CreateIndexes(t *testing.T) {
+	cmd := bson.D{{Key: "createIndexes", Value: "users"}}
+	reply, err := bson.Marshal(bson.D{
+		{Key: "ok", Value: 1},
+		{Key: "numIndexesBefore", Value: int32(1)},
+		{Key: "numIndexesAfter", Value: int32(3)},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	result := newCommandResult(0, "test", cmd, reply, 0, nil)
+	if result.IndexesBefore != 1 || result.IndexesAfter != 3 {
+		t.Fatalf("unexpected index counts: %+v", result)
+	}
+}
+
+func Test_driver_executeCommands_ResultHook(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Success", func(mt *mtest.T) {
+		var results []CommandResult
+		d, err := NewDriver(mt.Client, "test", WithCommandResultHook(func(result CommandResult) {
+			results = append(results, result)
+		}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 4}))
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 0}})
+
+		err = d.(*driver).executeCommands(mt.Context(), []migrationCommand{
+			{Command: bson.D{{Key: "delete", Value: "users"}}},
+			{Command: bson.D{{Key: "insert", Value: "users"}}},
+		})
+		if err == nil {
+			t.Fatalf("expected error, got: %v", err)
+		}
+
+		if len(results) != 2 {
+			t.Fatalf("unexpected number of results: %d", len(results))
+		}
+		if results[0].Command != "delete" || results[0].N != 4 || results[0].Err != nil {
+			t.Fatalf("unexpected first result: %+v", results[0])
+		}
+		if results[1].Command != "insert" || results[1].Err == nil || results[1].Reply != nil {
+			t.Fatalf("unexpected second result: %+v", results[1])
+		}
+	})
+}
+
+func Test_writeErrorsFromReply(t *testing.T) {
+	reply, err := bson.Marshal(bson.D{
+		{Key: "ok", Value: 1},
+		{Key: "n", Value: 1},
+		{Key: "writeErrors", Value: bson.A{
+			bson.D{{Key: "index", Value: 1}, {Key: "code", Value: 121}, {Key: "errmsg", Value: "Document failed validation"}},
+		}},
+		{Key: "writeConcernError", Value: bson.D{
+			{Key: "code", Value: 64}, {Key: "codeName", Value: "WriteConcernFailed"}, {Key: "errmsg", Value: "waiting for replication timed out"},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = writeErrorsFromReply("update", reply)
+	writeErr, ok := err.(CommandWriteError)
+	if !ok {
+		t.Fatalf("expected CommandWriteError, got: %v", err)
+	}
+	if writeErr.Command != "update" || len(writeErr.WriteErrors) != 1 || writeErr.WriteErrors[0].Code != 121 {
+		t.Fatalf("unexpected write errors: %+v", writeErr)
+	}
+	if writeErr.WriteConcernError == nil || writeErr.WriteConcernError.Name != "WriteConcernFailed" {
+		t.Fatalf("unexpected write concern error: %+v", writeErr.WriteConcernError)
+	}
+
+	expectedMsg := "command update reported errors: write error at index 1: (121) Document failed validation; " +
+		"write concern error: (WriteConcernFailed) waiting for replication timed out"
+	if writeErr.Error() != expectedMsg {
+		t.Fatalf("unexpected error message: %s", writeErr.Error())
+	}
+}
+
+func Test_writeErrorsFromReply_NoErrors(t *testing.T) {
+	reply, err := bson.Marshal(bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 1}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err = writeErrorsFromReply("update", reply); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func Test_driver_executeCommands_WriteErrors(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("WriteError", func(mt *mtest.T) {
+		d, err := NewDriver(mt.Client, "test")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{
+			Index:   0,
+			Code:    121,
+			Message: "Document failed validation",
+		}))
+
+		err = d.(*driver).executeCommands(mt.Context(), []migrationCommand{{Command: bson.D{{Key: "update", Value: "users"}}}})
+		var writeErr CommandWriteError
+		if !errors.As(err, &writeErr) {
+			t.Fatalf("expected CommandWriteError, got: %v", err)
+		}
+	})
+
+	mt.Run("WriteConcernError", func(mt *mtest.T) {
+		d, err := NewDriver(mt.Client, "test")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(mtest.CreateWriteConcernErrorResponse(mtest.WriteConcernError{
+			Name:    "WriteConcernFailed",
+			Code:    64,
+			Message: "waiting for replication timed out",
+		}))
+
+		err = d.(*driver).executeCommands(mt.Context(), []migrationCommand{{Command: bson.D{{Key: "update", Value: "users"}}}})
+		var writeErr CommandWriteError
+		if !errors.As(err, &writeErr) || writeErr.WriteConcernError == nil {
+			t.Fatalf("expected CommandWriteError, got: %v", err)
+		}
+	})
+}
@@ -0,0 +1,86 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// transientErrorLabels contains the error labels the server and client attach to errors that are worth retrying.
+var transientErrorLabels = []string{"RetryableWriteError", "TransientTransactionError"}
+
+// transientErrorCodes contains the codes of server errors caused by replica set state changes or network problems:
+// HostUnreachable, HostNotFound, NetworkTimeout, ShutdownInProgress, PrimarySteppedDown, SocketException,
+// NotWritablePrimary, InterruptedAtShutdown, InterruptedDueToReplStateChange, NotPrimaryNoSecondaryOk and
+// NotPrimaryOrSecondary.
+var transientErrorCodes = []int{6, 7, 89, 91, 189, 9001, 10107, 11600, 11602, 13435, 13436}
+
+// IsTransientError checks if the error is caused by a temporary condition, like a network error or a replica set
+// election. Errors labeled as RetryableWriteError or TransientTransactionError are transient, as well as server
+// errors that signal a replica set state change (e.g. NotWritablePrimary).
+func IsTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if mongo.IsNetworkError(err) {
+		return true
+	}
+
+	var labeled interface{ HasErrorLabel(string) bool }
+	if errors.As(err, &labeled) {
+		for _, label := range transientErrorLabels {
+			if labeled.HasErrorLabel(label) {
+				return true
+			}
+		}
+	}
+
+	var coded interface{ HasErrorCode(int) bool }
+	if errors.As(err, &coded) {
+		for _, code := range transientErrorCodes {
+			if coded.HasErrorCode(code) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// withRetry runs the operation until it succeeds, fails with a non-transient error, the maximum number of attempts
+// is reached or the context is done. Each retry is logged.
+func (d *driver) withRetry(ctx context.Context, operation string, fn func() error) error {
+	err := fn()
+	if !d.cfg.Retry.Enabled {
+		return err
+	}
+
+	retryable := d.cfg.Retry.Retryable
+	if retryable == nil {
+		retryable = IsTransientError
+	}
+
+	backoff := d.cfg.Retry.RetryInterval
+	for attempt := 1; err != nil && attempt < d.cfg.Retry.MaxAttempts && retryable(err); attempt++ {
+		d.logMessage("%s failed with transient error (attempt %d/%d), retrying in %s: %v",
+			operation, attempt, d.cfg.Retry.MaxAttempts, backoff, err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		err = fn()
+
+		backoff *= 2
+		if d.cfg.Retry.MaxRetryInterval > 0 && backoff > d.cfg.Retry.MaxRetryInterval {
+			backoff = d.cfg.Retry.MaxRetryInterval
+		}
+	}
+	return err
+}
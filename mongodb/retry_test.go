@@ -0,0 +1,179 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/h44z/lightmigrate"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestWithRetry(t *testing.T) {
+	d := &driver{cfg: &config{}}
+
+	WithRetry(RetryConfig{Enabled: true})(d)
+	retry := d.cfg.Retry
+	if !retry.Enabled || retry.MaxAttempts != DefaultRetryMaxAttempts || retry.RetryInterval != DefaultRetryInterval ||
+		retry.MaxRetryInterval != DefaultRetryMaxInterval || retry.Retryable != nil {
+		t.Fatalf("unexpected retry config: %v", retry)
+	}
+}
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "Nil", err: nil, want: false},
+		{name: "Other", err: errors.New("other"), want: false},
+		{name: "RetryableWriteError", err: mongo.CommandError{Code: 1, Labels: []string{"RetryableWriteError"}}, want: true},
+		{name: "NotWritablePrimary", err: mongo.CommandError{Code: 10107, Name: "NotWritablePrimary"}, want: true},
+		{name: "BadValue", err: mongo.CommandError{Code: 2, Name: "BadValue"}, want: false},
+		{name: "TransientTransactionError", err: CommandWriteError{Labels: []string{"TransientTransactionError"}}, want: true},
+		{name: "WriteConcernError", err: CommandWriteError{WriteConcernError: &mongo.WriteConcernError{Code: 91}}, want: true},
+		{name: "DuplicateKey", err: CommandWriteError{WriteErrors: []mongo.WriteError{{Code: 11000}}}, want: false},
+		{name: "Wrapped", err: &lightmigrate.DriverError{OrigErr: mongo.CommandError{Code: 189}}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsTransientError(tt.err); got != tt.want {
+				t.Fatalf("unexpected result: %t", got)
+			}
+		})
+	}
+}
+
+func Test_driver_executeCommands_Retry(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	notWritablePrimary := bson.D{{Key: "ok", Value: 0}, {Key: "code", Value: 10107},
+		{Key: "codeName", Value: "NotWritablePrimary"}, {Key: "errmsg", Value: "not primary"}}
+
+	mt.Run("Success", func(mt *mtest.T) {
+		logger := &testLogger{}
+		d, err := NewDriver(mt.Client, "test", WithLogger(logger),
+			WithRetry(RetryConfig{Enabled: true, RetryInterval: time.Millisecond}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(notWritablePrimary)
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		err = d.(*driver).executeCommands(context.Background(), []migrationCommand{{Command: bson.D{{Key: "ping", Value: 1}}}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(logger.messages) != 1 || !strings.Contains(logger.messages[0], "command 1/1 failed with transient error (attempt 1/3)") {
+			t.Fatalf("unexpected log messages: %v", logger.messages)
+		}
+	})
+
+	mt.Run("MaxAttempts", func(mt *mtest.T) {
+		d, err := NewDriver(mt.Client, "test", WithLogger(&testLogger{}),
+			WithRetry(RetryConfig{Enabled: true, MaxAttempts: 2, RetryInterval: time.Millisecond}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(notWritablePrimary)
+		mt.AddMockResponses(notWritablePrimary)
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		err = d.(*driver).executeCommands(context.Background(), []migrationCommand{{Command: bson.D{{Key: "ping", Value: 1}}}})
+		if !IsTransientError(err) {
+			t.Fatalf("expected transient error, got: %v", err)
+		}
+	})
+
+	mt.Run("NotTransient", func(mt *mtest.T) {
+		d, err := NewDriver(mt.Client, "test", WithLogger(&testLogger{}),
+			WithRetry(RetryConfig{Enabled: true, RetryInterval: time.Millisecond}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 0}, {Key: "code", Value: 2}, {Key: "errmsg", Value: "bad value"}})
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		err = d.(*driver).executeCommands(context.Background(), []migrationCommand{{Command: bson.D{{Key: "ping", Value: 1}}}})
+		if err == nil {
+			t.Fatalf("expected error, got: %v", err)
+		}
+	})
+
+	mt.Run("Disabled", func(mt *mtest.T) {
+		d, err := NewDriver(mt.Client, "test")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(notWritablePrimary)
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		err = d.(*driver).executeCommands(context.Background(), []migrationCommand{{Command: bson.D{{Key: "ping", Value: 1}}}})
+		if err == nil {
+			t.Fatalf("expected error, got: %v", err)
+		}
+	})
+}
+
+func Test_driver_executeCommandsWithTransaction_Retry(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Success", func(mt *mtest.T) {
+		logger := &testLogger{}
+		d, err := NewDriver(mt.Client, "test", WithLogger(logger), WithTransactions(true),
+			WithRetry(RetryConfig{Enabled: true, RetryInterval: time.Millisecond}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 0}, {Key: "code", Value: 10107},
+			{Key: "codeName", Value: "NotWritablePrimary"}, {Key: "errmsg", Value: "not primary"}})
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // abort
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // command
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // commit
+
+		err = d.(*driver).executeCommandsWithTransaction(context.Background(),
+			[]migrationCommand{{Command: bson.D{{Key: "ping", Value: 1}}}}, nil, true)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(logger.messages) != 1 || !strings.Contains(logger.messages[0], "transaction failed with transient error") {
+			t.Fatalf("unexpected log messages: %v", logger.messages)
+		}
+	})
+
+	mt.Run("Timeout", func(mt *mtest.T) {
+		d, err := NewDriver(mt.Client, "test", WithLogger(&testLogger{}), WithTransactions(true),
+			WithTransactionConfig(TransactionConfig{Timeout: 50 * time.Millisecond}),
+			WithRetry(RetryConfig{Enabled: true, MaxAttempts: 5, RetryInterval: time.Second}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 0}, {Key: "code", Value: 10107},
+			{Key: "codeName", Value: "NotWritablePrimary"}, {Key: "errmsg", Value: "not primary"}})
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // abort
+
+		startedAt := time.Now()
+		err = d.(*driver).executeCommandsWithTransaction(context.Background(),
+			[]migrationCommand{{Command: bson.D{{Key: "ping", Value: 1}}}}, nil, true)
+		if err == nil {
+			t.Fatalf("expected error, got: %v", err)
+		}
+		if elapsed := time.Since(startedAt); elapsed >= time.Second {
+			t.Fatalf("expected the transaction timeout to bound all retries, took: %s", elapsed)
+		}
+	})
+}
@@ -0,0 +1,743 @@
+package mongodb
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// scriptDatabaseIdent is the name of the database object every statement of a migration script starts with.
+const scriptDatabaseIdent = "db"
+
+// scriptDateLayouts are the accepted date formats of ISODate and new Date. Dates without zone are UTC.
+var scriptDateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// scriptTarget is the collection a shell helper is called on.
+type scriptTarget struct {
+	// Database is the database selected with getSiblingDB, empty for the migration database.
+	Database string
+	// MigrationDatabase is the name of the migration database, used for fully qualified namespaces.
+	MigrationDatabase string
+	// Collection is the name of the collection.
+	Collection string
+}
+
+// scriptHelper translates a shell helper call into a database command.
+// If the arguments are invalid, a message describing the problem is returned.
+type scriptHelper struct {
+	minArgs int
+	maxArgs int
+	build   func(target scriptTarget, args []interface{}) (migrationCommand, string)
+}
+
+// scriptHelpers contains all collection helpers that can be used in migration scripts.
+var scriptHelpers = map[string]scriptHelper{
+	"insertOne":        {minArgs: 1, maxArgs: 2, build: buildInsertOne},
+	"insertMany":       {minArgs: 1, maxArgs: 2, build: buildInsertMany},
+	"updateOne":        {minArgs: 2, maxArgs: 3, build: buildUpdate(false)},
+	"updateMany":       {minArgs: 2, maxArgs: 3, build: buildUpdate(true)},
+	"deleteMany":       {minArgs: 1, maxArgs: 2, build: buildDeleteMany},
+	"createIndex":      {minArgs: 1, maxArgs: 2, build: buildCreateIndex},
+	"dropIndex":        {minArgs: 1, maxArgs: 1, build: buildDropIndex},
+	"renameCollection": {minArgs: 1, maxArgs: 2, build: buildRenameCollection},
+	"aggregate":        {minArgs: 1, maxArgs: 2, build: buildAggregate},
+}
+
+// isScript checks if the migration is a mongo shell script, i.e. if it starts with a statement on the db object.
+func isScript(migr []byte) bool {
+	p := &scriptParser{src: migr}
+	p.skipSpace()
+	return p.ident() == scriptDatabaseIdent
+}
+
+// parseScript parses a migration written in a restricted mongo shell syntax and translates each helper call into
+// a database command. The database name is used for commands that require a fully qualified namespace.
+//
+// Each statement has the form db.<collection>.<helper>(<arguments>), optionally followed by a semicolon.
+// The collection can also be selected with db.getCollection("name") and the database with db.getSiblingDB("name").
+// Arguments are JavaScript literals (objects with unquoted keys, arrays, strings, numbers, booleans and null) and
+// the constructors ObjectId, ISODate, new Date, NumberInt, NumberLong and NumberDecimal.
+func parseScript(migr []byte, database string) ([]migrationCommand, error) {
+	p := &scriptParser{src: migr, database: database}
+
+	var cmds []migrationCommand
+	for p.index = 0; ; p.index++ {
+		p.skipSpace()
+		if p.eof() {
+			break
+		}
+
+		cmd, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, cmd)
+	}
+
+	return cmds, nil
+}
+
+// scriptParser is a recursive descent parser for migration scripts.
+type scriptParser struct {
+	src      []byte
+	pos      int
+	index    int    // index of the current statement
+	database string // name of the migration database
+}
+
+func (p *scriptParser) errorAt(offset int, msg string, err error) MigrationFormatError {
+	return newFormatError(p.src, p.index, int64(offset), msg, err)
+}
+
+func (p *scriptParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *scriptParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+// consume skips the given character if it is the next one.
+func (p *scriptParser) consume(c byte) bool {
+	if p.peek() != c {
+		return false
+	}
+	p.pos++
+	return true
+}
+
+// expect skips whitespace and the given character, or returns an error if it is not the next character.
+func (p *scriptParser) expect(c byte) error {
+	p.skipSpace()
+	if !p.consume(c) {
+		return p.errorAt(p.pos, fmt.Sprintf("expected %q", c), nil)
+	}
+	return nil
+}
+
+// skipSpace skips whitespace and comments.
+func (p *scriptParser) skipSpace() {
+	for !p.eof() {
+		switch {
+		case bytes.IndexByte([]byte(" \t\r\n"), p.peek()) >= 0:
+			p.pos++
+		case bytes.HasPrefix(p.src[p.pos:], []byte("//")):
+			end := bytes.IndexByte(p.src[p.pos:], '\n')
+			if end < 0 {
+				p.pos = len(p.src)
+			} else {
+				p.pos += end + 1
+			}
+		case bytes.HasPrefix(p.src[p.pos:], []byte("/*")):
+			end := bytes.Index(p.src[p.pos+2:], []byte("*/"))
+			if end < 0 {
+				p.pos = len(p.src)
+			} else {
+				p.pos += end + 4
+			}
+		default:
+			return
+		}
+	}
+}
+
+// ident reads an identifier, an empty string is returned if there is none.
+func (p *scriptParser) ident() string {
+	start := p.pos
+	for !p.eof() {
+		c := p.peek()
+		isLetter := c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		isDigit := c >= '0' && c <= '9'
+		if !isLetter && !(isDigit && p.pos > start) {
+			break
+		}
+		p.pos++
+	}
+	return string(p.src[start:p.pos])
+}
+
+// statement parses a single helper call on a collection of the db object.
+func (p *scriptParser) statement() (migrationCommand, error) {
+	start := p.pos
+	if p.ident() != scriptDatabaseIdent {
+		return migrationCommand{}, p.errorAt(start, "statement must start with "+scriptDatabaseIdent, nil)
+	}
+
+	target := scriptTarget{MigrationDatabase: p.database}
+	var path []string
+	for {
+		if err := p.expect('.'); err != nil {
+			return migrationCommand{}, err
+		}
+		p.skipSpace()
+		nameOffset := p.pos
+		name := p.ident()
+		if name == "" {
+			return migrationCommand{}, p.errorAt(nameOffset, "expected identifier", nil)
+		}
+		p.skipSpace()
+		if p.peek() != '(' {
+			path = append(path, name)
+			continue
+		}
+
+		args, err := p.arguments()
+		if err != nil {
+			return migrationCommand{}, err
+		}
+
+		switch {
+		case name == "getSiblingDB" && len(path) == 0 && target.Database == "":
+			database, ok := singleStringArg(args)
+			if !ok || database == "" {
+				return migrationCommand{}, p.errorAt(nameOffset, "getSiblingDB requires a database name", nil)
+			}
+			target.Database = database
+			target.MigrationDatabase = database
+		case name == "getCollection" && len(path) == 0:
+			collection, ok := singleStringArg(args)
+			if !ok || collection == "" {
+				return migrationCommand{}, p.errorAt(nameOffset, "getCollection requires a collection name", nil)
+			}
+			path = append(path, collection)
+		default:
+			target.Collection = strings.Join(path, ".")
+			cmd, err := p.helperCall(target, name, args, nameOffset)
+			if err != nil {
+				return migrationCommand{}, err
+			}
+			p.skipSpace()
+			p.consume(';')
+			return cmd, nil
+		}
+	}
+}
+
+// helperCall validates and translates a single helper call.
+func (p *scriptParser) helperCall(target scriptTarget, name string, args []interface{}, offset int) (migrationCommand, error) {
+	if target.Collection == "" {
+		return migrationCommand{}, p.errorAt(offset, fmt.Sprintf("%s must be called on a collection", name), nil)
+	}
+
+	helper, ok := scriptHelpers[name]
+	if !ok {
+		return migrationCommand{}, p.errorAt(offset, fmt.Sprintf("unsupported helper %q", name), nil)
+	}
+	if len(args) < helper.minArgs || len(args) > helper.maxArgs {
+		return migrationCommand{}, p.errorAt(offset,
+			fmt.Sprintf("%s expects %d to %d arguments, got %d", name, helper.minArgs, helper.maxArgs, len(args)), nil)
+	}
+
+	cmd, msg := helper.build(target, args)
+	if msg != "" {
+		return migrationCommand{}, p.errorAt(offset, name+": "+msg, nil)
+	}
+	return cmd, nil
+}
+
+// arguments parses a parenthesized, comma separated list of values.
+func (p *scriptParser) arguments() ([]interface{}, error) {
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+
+	args := []interface{}{}
+	for {
+		p.skipSpace()
+		if p.consume(')') {
+			return args, nil
+		}
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, value)
+
+		p.skipSpace()
+		if !p.consume(',') && p.peek() != ')' {
+			return nil, p.errorAt(p.pos, "expected ',' or ')'", nil)
+		}
+	}
+}
+
+// value parses a JavaScript literal or constructor call.
+func (p *scriptParser) value() (interface{}, error) {
+	p.skipSpace()
+	switch c := p.peek(); {
+	case c == '{':
+		return p.object()
+	case c == '[':
+		return p.array()
+	case c == '"' || c == '\'':
+		return p.string()
+	case c == '-' || c == '+' || c == '.' || (c >= '0' && c <= '9'):
+		return p.number()
+	}
+
+	start := p.pos
+	name := p.ident()
+	switch name {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	case "":
+		return nil, p.errorAt(start, "expected value", nil)
+	case "new":
+		p.skipSpace()
+		start = p.pos
+		name = p.ident()
+	}
+	return p.constructor(name, start)
+}
+
+// object parses an object literal, keys can be identifiers or strings.
+func (p *scriptParser) object() (bson.D, error) {
+	p.consume('{')
+
+	doc := bson.D{}
+	for {
+		p.skipSpace()
+		if p.consume('}') {
+			return doc, nil
+		}
+
+		var key string
+		if c := p.peek(); c == '"' || c == '\'' {
+			var err error
+			if key, err = p.string(); err != nil {
+				return nil, err
+			}
+		} else if key = p.ident(); key == "" {
+			return nil, p.errorAt(p.pos, "expected key", nil)
+		}
+		if err := p.expect(':'); err != nil {
+			return nil, err
+		}
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		doc = append(doc, bson.E{Key: key, Value: value})
+
+		p.skipSpace()
+		if !p.consume(',') && p.peek() != '}' {
+			return nil, p.errorAt(p.pos, "expected ',' or '}'", nil)
+		}
+	}
+}
+
+// array parses an array literal.
+func (p *scriptParser) array() (bson.A, error) {
+	p.consume('[')
+
+	arr := bson.A{}
+	for {
+		p.skipSpace()
+		if p.consume(']') {
+			return arr, nil
+		}
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, value)
+
+		p.skipSpace()
+		if !p.consume(',') && p.peek() != ']' {
+			return nil, p.errorAt(p.pos, "expected ',' or ']'", nil)
+		}
+	}
+}
+
+// string parses a single or double quoted string literal.
+func (p *scriptParser) string() (string, error) {
+	start := p.pos
+	quote := p.src[p.pos]
+	p.pos++
+
+	var sb strings.Builder
+	for !p.eof() {
+		c := p.src[p.pos]
+		switch {
+		case c == quote:
+			p.pos++
+			return sb.String(), nil
+		case c == '\n':
+			return "", p.errorAt(p.pos, "unterminated string", nil)
+		case c == '\\' && p.pos+1 < len(p.src):
+			p.pos++
+			switch esc := p.src[p.pos]; esc {
+			case 'b':
+				sb.WriteByte('\b')
+			case 'f':
+				sb.WriteByte('\f')
+			case 'n':
+				sb.WriteByte('\n')
+			case 'r':
+				sb.WriteByte('\r')
+			case 't':
+				sb.WriteByte('\t')
+			case 'u':
+				if p.pos+4 >= len(p.src) {
+					return "", p.errorAt(p.pos, "invalid unicode escape", nil)
+				}
+				r, err := strconv.ParseUint(string(p.src[p.pos+1:p.pos+5]), 16, 32)
+				if err != nil {
+					return "", p.errorAt(p.pos, "invalid unicode escape", err)
+				}
+				sb.WriteRune(rune(r))
+				p.pos += 4
+			default:
+				sb.WriteByte(esc)
+			}
+			p.pos++
+		default:
+			r, size := utf8.DecodeRune(p.src[p.pos:])
+			sb.WriteRune(r)
+			p.pos += size
+		}
+	}
+
+	return "", p.errorAt(start, "unterminated string", nil)
+}
+
+// number parses a numeric literal. Integers are int32 if they fit and int64 otherwise, all other numbers
+// are float64, the same types the JSON format produces.
+func (p *scriptParser) number() (interface{}, error) {
+	start := p.pos
+	for !p.eof() && bytes.IndexByte([]byte("+-.0123456789eE"), p.peek()) >= 0 {
+		p.pos++
+	}
+	literal := string(p.src[start:p.pos])
+
+	if i, err := strconv.ParseInt(literal, 10, 64); err == nil {
+		if int64(int32(i)) == i {
+			return int32(i), nil
+		}
+		return i, nil
+	}
+	f, err := strconv.ParseFloat(literal, 64)
+	if err != nil {
+		return nil, p.errorAt(start, "invalid number", err)
+	}
+	return f, nil
+}
+
+// constructor parses the call of a shell type constructor like ObjectId("...").
+func (p *scriptParser) constructor(name string, offset int) (interface{}, error) {
+	p.skipSpace()
+	if p.peek() != '(' {
+		return nil, p.errorAt(offset, fmt.Sprintf("unexpected identifier %q", name), nil)
+	}
+	args, err := p.arguments()
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != 1 {
+		return nil, p.errorAt(offset, fmt.Sprintf("%s expects exactly one argument", name), nil)
+	}
+
+	var value interface{}
+	switch name {
+	case "ObjectId":
+		value, err = scriptObjectID(args[0])
+	case "ISODate", "Date":
+		value, err = scriptDate(args[0])
+	case "NumberInt":
+		value, err = scriptInt(args[0], 32)
+	case "NumberLong":
+		value, err = scriptInt(args[0], 64)
+	case "NumberDecimal":
+		value, err = scriptDecimal(args[0])
+	default:
+		return nil, p.errorAt(offset, fmt.Sprintf("unsupported constructor %q", name), nil)
+	}
+	if err != nil {
+		return nil, p.errorAt(offset, "invalid "+name, err)
+	}
+	return value, nil
+}
+
+func scriptObjectID(arg interface{}) (interface{}, error) {
+	hex, ok := arg.(string)
+	if !ok {
+		return nil, fmt.Errorf("expected string, got %T", arg)
+	}
+	return primitive.ObjectIDFromHex(hex)
+}
+
+func scriptDate(arg interface{}) (interface{}, error) {
+	switch v := arg.(type) {
+	case string:
+		for _, layout := range scriptDateLayouts {
+			if t, err := time.Parse(layout, v); err == nil {
+				return primitive.NewDateTimeFromTime(t), nil
+			}
+		}
+		return nil, fmt.Errorf("unsupported date format %q", v)
+	case int32:
+		return primitive.DateTime(v), nil
+	case int64:
+		return primitive.DateTime(v), nil
+	default:
+		return nil, fmt.Errorf("expected string or milliseconds, got %T", arg)
+	}
+}
+
+func scriptInt(arg interface{}, bitSize int) (interface{}, error) {
+	var i int64
+	switch v := arg.(type) {
+	case string:
+		var err error
+		if i, err = strconv.ParseInt(v, 10, bitSize); err != nil {
+			return nil, err
+		}
+	case int32:
+		i = int64(v)
+	case int64:
+		i = v
+	default:
+		return nil, fmt.Errorf("expected string or integer, got %T", arg)
+	}
+
+	if bitSize == 32 {
+		if int64(int32(i)) != i {
+			return nil, fmt.Errorf("value %d out of range", i)
+		}
+		return int32(i), nil
+	}
+	return i, nil
+}
+
+func scriptDecimal(arg interface{}) (interface{}, error) {
+	s, ok := arg.(string)
+	if !ok {
+		return nil, fmt.Errorf("expected string, got %T", arg)
+	}
+	return primitive.ParseDecimal128(s)
+}
+
+// singleStringArg returns the only argument if it is a string.
+func singleStringArg(args []interface{}) (string, bool) {
+	if len(args) != 1 {
+		return "", false
+	}
+	s, ok := args[0].(string)
+	return s, ok
+}
+
+// documentArg returns the optional document argument at the given position.
+func documentArg(args []interface{}, pos int, name string) (bson.D, string) {
+	if pos >= len(args) {
+		return nil, ""
+	}
+	doc, ok := args[pos].(bson.D)
+	if !ok {
+		return nil, fmt.Sprintf("%s must be a document", name)
+	}
+	return doc, ""
+}
+
+// splitOptions splits helper options into the fields with the given keys and all other fields.
+func splitOptions(opts bson.D, keys ...string) (matched, other bson.D) {
+	for _, elem := range opts {
+		found := false
+		for _, key := range keys {
+			if elem.Key == key {
+				found = true
+				break
+			}
+		}
+		if found {
+			matched = append(matched, elem)
+		} else {
+			other = append(other, elem)
+		}
+	}
+	return matched, other
+}
+
+func buildInsertOne(target scriptTarget, args []interface{}) (migrationCommand, string) {
+	doc, msg := documentArg(args, 0, "document")
+	if msg != "" {
+		return migrationCommand{}, msg
+	}
+	opts, msg := documentArg(args, 1, "options")
+	if msg != "" {
+		return migrationCommand{}, msg
+	}
+
+	cmd := bson.D{{Key: "insert", Value: target.Collection}, {Key: "documents", Value: bson.A{doc}}}
+	return migrationCommand{Database: target.Database, Command: append(cmd, opts...)}, ""
+}
+
+func buildInsertMany(target scriptTarget, args []interface{}) (migrationCommand, string) {
+	docs, ok := args[0].(bson.A)
+	if !ok || len(docs) == 0 {
+		return migrationCommand{}, "documents must be a non-empty array"
+	}
+	for _, doc := range docs {
+		if _, ok := doc.(bson.D); !ok {
+			return migrationCommand{}, "documents must only contain documents"
+		}
+	}
+	opts, msg := documentArg(args, 1, "options")
+	if msg != "" {
+		return migrationCommand{}, msg
+	}
+
+	cmd := bson.D{{Key: "insert", Value: target.Collection}, {Key: "documents", Value: docs}}
+	return migrationCommand{Database: target.Database, Command: append(cmd, opts...)}, ""
+}
+
+func buildUpdate(multi bool) func(target scriptTarget, args []interface{}) (migrationCommand, string) {
+	return func(target scriptTarget, args []interface{}) (migrationCommand, string) {
+		filter, msg := documentArg(args, 0, "filter")
+		if msg != "" {
+			return migrationCommand{}, msg
+		}
+		update := args[1]
+		if _, isPipeline := update.(bson.A); !isPipeline {
+			if _, isDocument := update.(bson.D); !isDocument {
+				return migrationCommand{}, "update must be a document or a pipeline"
+			}
+		}
+		opts, msg := documentArg(args, 2, "options")
+		if msg != "" {
+			return migrationCommand{}, msg
+		}
+
+		statementOpts, commandOpts := splitOptions(opts, "upsert", "arrayFilters", "hint", "collation")
+		statement := bson.D{{Key: "q", Value: filter}, {Key: "u", Value: update}, {Key: "multi", Value: multi}}
+		cmd := bson.D{
+			{Key: "update", Value: target.Collection},
+			{Key: "updates", Value: bson.A{append(statement, statementOpts...)}},
+		}
+		return migrationCommand{Database: target.Database, Command: append(cmd, commandOpts...)}, ""
+	}
+}
+
+func buildDeleteMany(target scriptTarget, args []interface{}) (migrationCommand, string) {
+	filter, msg := documentArg(args, 0, "filter")
+	if msg != "" {
+		return migrationCommand{}, msg
+	}
+	opts, msg := documentArg(args, 1, "options")
+	if msg != "" {
+		return migrationCommand{}, msg
+	}
+
+	statementOpts, commandOpts := splitOptions(opts, "hint", "collation")
+	statement := bson.D{{Key: "q", Value: filter}, {Key: "limit", Value: int32(0)}}
+	cmd := bson.D{
+		{Key: "delete", Value: target.Collection},
+		{Key: "deletes", Value: bson.A{append(statement, statementOpts...)}},
+	}
+	return migrationCommand{Database: target.Database, Command: append(cmd, commandOpts...)}, ""
+}
+
+func buildCreateIndex(target scriptTarget, args []interface{}) (migrationCommand, string) {
+	keys, msg := documentArg(args, 0, "keys")
+	if msg != "" {
+		return migrationCommand{}, msg
+	}
+	if len(keys) == 0 {
+		return migrationCommand{}, "keys must not be empty"
+	}
+	opts, msg := documentArg(args, 1, "options")
+	if msg != "" {
+		return migrationCommand{}, msg
+	}
+
+	commandOpts, indexOpts := splitOptions(opts, "commitQuorum", "writeConcern")
+	nameOpts, indexOpts := splitOptions(indexOpts, "name")
+	name := indexName(keys)
+	if len(nameOpts) != 0 {
+		if name, _ = nameOpts[0].Value.(string); name == "" {
+			return migrationCommand{}, "name must be a non-empty string"
+		}
+	}
+
+	index := bson.D{{Key: "key", Value: keys}, {Key: "name", Value: name}}
+	cmd := bson.D{
+		{Key: "createIndexes", Value: target.Collection},
+		{Key: "indexes", Value: bson.A{append(index, indexOpts...)}},
+	}
+	return migrationCommand{Database: target.Database, Command: append(cmd, commandOpts...)}, ""
+}
+
+// indexName generates the default name of an index the same way the MongoDB server and shell do, e.g. "a_1_b_-1".
+func indexName(keys bson.D) string {
+	parts := make([]string, 0, len(keys)*2)
+	for _, key := range keys {
+		parts = append(parts, key.Key, fmt.Sprint(key.Value))
+	}
+	return strings.Join(parts, "_")
+}
+
+func buildDropIndex(target scriptTarget, args []interface{}) (migrationCommand, string) {
+	switch index := args[0].(type) {
+	case string, bson.D:
+		cmd := bson.D{{Key: "dropIndexes", Value: target.Collection}, {Key: "index", Value: index}}
+		return migrationCommand{Database: target.Database, Command: cmd}, ""
+	default:
+		return migrationCommand{}, "index must be a name or a key document"
+	}
+}
+
+// buildRenameCollection translates renameCollection into the admin command that requires fully qualified namespaces.
+func buildRenameCollection(target scriptTarget, args []interface{}) (migrationCommand, string) {
+	to, ok := args[0].(string)
+	if !ok || to == "" {
+		return migrationCommand{}, "target name must be a non-empty string"
+	}
+
+	cmd := bson.D{
+		{Key: "renameCollection", Value: target.MigrationDatabase + "." + target.Collection},
+		{Key: "to", Value: target.MigrationDatabase + "." + to},
+	}
+	if len(args) > 1 {
+		dropTarget, ok := args[1].(bool)
+		if !ok {
+			return migrationCommand{}, "dropTarget must be a boolean"
+		}
+		cmd = append(cmd, bson.E{Key: "dropTarget", Value: dropTarget})
+	}
+	return migrationCommand{Database: "admin", Command: cmd}, ""
+}
+
+func buildAggregate(target scriptTarget, args []interface{}) (migrationCommand, string) {
+	pipeline, ok := args[0].(bson.A)
+	if !ok {
+		return migrationCommand{}, "pipeline must be an array"
+	}
+	opts, msg := documentArg(args, 1, "options")
+	if msg != "" {
+		return migrationCommand{}, msg
+	}
+
+	cursorOpts, commandOpts := splitOptions(opts, "batchSize")
+	cmd := bson.D{
+		{Key: "aggregate", Value: target.Collection},
+		{Key: "pipeline", Value: pipeline},
+		{Key: "cursor", Value: append(bson.D{}, cursorOpts...)},
+	}
+	return migrationCommand{Database: target.Database, Command: append(cmd, commandOpts...)}, ""
+}
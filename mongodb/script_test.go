@@ -0,0 +1,178 @@
+package mongodb
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func Test_isScript(t *testing.T) {
+	if !isScript([]byte("// add users\ndb.users.insertOne({})")) {
+		t.Fatalf("expected script")
+	}
+	if isScript([]byte(`[{"ping": 1}]`)) {
+		t.Fatalf("unexpected script")
+	}
+	if isScript([]byte("dbx.users.insertOne({})")) {
+		t.Fatalf("unexpected script")
+	}
+}
+
+func Test_parseScript(t *testing.T) {
+	oid := primitive.NewObjectID()
+	created := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		script string
+		want   migrationCommand
+	}{
+		{
+			name:   "insertOne",
+			script: `db.users.insertOne({_id: ObjectId("` + oid.Hex() + `"), 'name': "admin", created: ISODate("2022-01-01T00:00:00Z"), logins: NumberLong("5")})`,
+			want: migrationCommand{Command: bson.D{{Key: "insert", Value: "users"}, {Key: "documents", Value: bson.A{bson.D{
+				{Key: "_id", Value: oid}, {Key: "name", Value: "admin"},
+				{Key: "created", Value: primitive.NewDateTimeFromTime(created)}, {Key: "logins", Value: int64(5)},
+			}}}}},
+		},
+		{
+			name:   "insertMany",
+			script: `db.users.insertMany([{n: 1}, {n: 2.5},], {ordered: false});`,
+			want: migrationCommand{Command: bson.D{{Key: "insert", Value: "users"},
+				{Key: "documents", Value: bson.A{bson.D{{Key: "n", Value: int32(1)}}, bson.D{{Key: "n", Value: 2.5}}}},
+				{Key: "ordered", Value: false}}},
+		},
+		{
+			name:   "updateMany",
+			script: `db.getCollection("users").updateMany({status: null}, {$set: {status: "active"}}, {upsert: true, writeConcern: {w: "majority"}})`,
+			want: migrationCommand{Command: bson.D{{Key: "update", Value: "users"}, {Key: "updates", Value: bson.A{bson.D{
+				{Key: "q", Value: bson.D{{Key: "status", Value: nil}}},
+				{Key: "u", Value: bson.D{{Key: "$set", Value: bson.D{{Key: "status", Value: "active"}}}}},
+				{Key: "multi", Value: true}, {Key: "upsert", Value: true},
+			}}}, {Key: "writeConcern", Value: bson.D{{Key: "w", Value: "majority"}}}}},
+		},
+		{
+			name:   "updateOne",
+			script: `db.users.updateOne({}, [{$set: {n: -1}}])`,
+			want: migrationCommand{Command: bson.D{{Key: "update", Value: "users"}, {Key: "updates", Value: bson.A{bson.D{
+				{Key: "q", Value: bson.D{}},
+				{Key: "u", Value: bson.A{bson.D{{Key: "$set", Value: bson.D{{Key: "n", Value: int32(-1)}}}}}},
+				{Key: "multi", Value: false},
+			}}}}},
+		},
+		{
+			name:   "deleteMany",
+			script: `db.sessions.deleteMany({expired: true})`,
+			want: migrationCommand{Command: bson.D{{Key: "delete", Value: "sessions"}, {Key: "deletes", Value: bson.A{bson.D{
+				{Key: "q", Value: bson.D{{Key: "expired", Value: true}}}, {Key: "limit", Value: int32(0)},
+			}}}}},
+		},
+		{
+			name:   "createIndex",
+			script: `db.users.createIndex({email: 1, created: -1}, {unique: true})`,
+			want: migrationCommand{Command: bson.D{{Key: "createIndexes", Value: "users"}, {Key: "indexes", Value: bson.A{bson.D{
+				{Key: "key", Value: bson.D{{Key: "email", Value: int32(1)}, {Key: "created", Value: int32(-1)}}},
+				{Key: "name", Value: "email_1_created_-1"}, {Key: "unique", Value: true},
+			}}}}},
+		},
+		{
+			name:   "dropIndex",
+			script: `db.users.dropIndex("email_1")`,
+			want:   migrationCommand{Command: bson.D{{Key: "dropIndexes", Value: "users"}, {Key: "index", Value: "email_1"}}},
+		},
+		{
+			name:   "renameCollection",
+			script: `db.users.renameCollection("members", true)`,
+			want: migrationCommand{Database: "admin", Command: bson.D{{Key: "renameCollection", Value: "test.users"},
+				{Key: "to", Value: "test.members"}, {Key: "dropTarget", Value: true}}},
+		},
+		{
+			name:   "aggregate",
+			script: `db.getSiblingDB("reports").orders.aggregate([{$match: {}}, {$out: "totals"}], {allowDiskUse: true})`,
+			want: migrationCommand{Database: "reports", Command: bson.D{{Key: "aggregate", Value: "orders"},
+				{Key: "pipeline", Value: bson.A{bson.D{{Key: "$match", Value: bson.D{}}}, bson.D{{Key: "$out", Value: "totals"}}}},
+				{Key: "cursor", Value: bson.D{}}, {Key: "allowDiskUse", Value: true}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmds, err := parseScript([]byte(tt.script), "test")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(cmds) != 1 || !reflect.DeepEqual(cmds[0], tt.want) {
+				t.Fatalf("unexpected commands: %v, want: %v", cmds, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseScript_MultipleStatements(t *testing.T) {
+	cmds, err := parseScript([]byte(`
+/* add the user collection */
+db.users.createIndex({email: 1});
+// seed data
+db.users.insertOne({email: "admin@example.com", created: new Date("2022-01-01")})
+`), "test")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cmds) != 2 || commandName(cmds[0].Command) != "createIndexes" || commandName(cmds[1].Command) != "insert" {
+		t.Fatalf("unexpected commands: %v", cmds)
+	}
+}
+
+func Test_parseScript_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		index  int
+		line   int
+		column int
+		msg    string
+	}{
+		{name: "NoDb", script: "db.users.insertOne({})\nusers.insertOne({})", index: 1, line: 2, column: 1, msg: "statement must start with db"},
+		{name: "Unsupported", script: "db.users.find({})", index: 0, line: 1, column: 10, msg: `unsupported helper "find"`},
+		{name: "NoCollection", script: "db.insertOne({})", index: 0, line: 1, column: 4, msg: "insertOne must be called on a collection"},
+		{name: "Arguments", script: "db.users.updateOne({})", index: 0, line: 1, column: 10, msg: "updateOne expects 2 to 3 arguments, got 1"},
+		{name: "ArgumentType", script: "db.users.insertMany({})", index: 0, line: 1, column: 10, msg: "insertMany: documents must be a non-empty array"},
+		{name: "Syntax", script: "db.users.insertOne({a 1})", index: 0, line: 1, column: 23, msg: "expected ':'"},
+		{name: "Constructor", script: "db.users.insertOne({a: ObjectId(\"x\")})", index: 0, line: 1, column: 24, msg: "invalid ObjectId"},
+		{name: "UnknownConstructor", script: "db.users.insertOne({a: UUID(\"x\")})", index: 0, line: 1, column: 24, msg: `unsupported constructor "UUID"`},
+		{name: "Unterminated", script: "db.users.insertOne({a: \"x})", index: 0, line: 1, column: 24, msg: "unterminated string"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseScript([]byte(tt.script), "test")
+			var formatErr MigrationFormatError
+			if !errors.As(err, &formatErr) {
+				t.Fatalf("expected MigrationFormatError, got: %v", err)
+			}
+			if formatErr.Index != tt.index || formatErr.Line != tt.line || formatErr.Column != tt.column {
+				t.Fatalf("unexpected position: %d (line %d, column %d)", formatErr.Index, formatErr.Line, formatErr.Column)
+			}
+			if !strings.Contains(formatErr.Msg, tt.msg) {
+				t.Fatalf("unexpected message: %s", formatErr.Msg)
+			}
+		})
+	}
+}
+
+func Test_decodeMigration(t *testing.T) {
+	cmds, err := decodeMigration([]byte(`db.users.dropIndex("email_1")`), "test")
+	if err != nil || len(cmds) != 1 || commandName(cmds[0].Command) != "dropIndexes" {
+		t.Fatalf("unexpected script result: %v, %v", cmds, err)
+	}
+
+	cmds, err = decodeMigration([]byte(`[{"ping": 1}]`), "test")
+	if err != nil || len(cmds) != 1 || commandName(cmds[0].Command) != "ping" {
+		t.Fatalf("unexpected json result: %v, %v", cmds, err)
+	}
+}
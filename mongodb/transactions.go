@@ -0,0 +1,144 @@
+package mongodb
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// NonTransactionalPolicy describes how the driver handles migration commands that are not allowed within
+// multi-document transactions (e.g. createUser or dropDatabase), if transactions are enabled.
+type NonTransactionalPolicy int
+
+const (
+	// NonTransactionalFail aborts the migration with a NonTransactionalCommandError before the transaction is started.
+	NonTransactionalFail NonTransactionalPolicy = iota
+	// NonTransactionalSplit splits the migration into segments. Commands that are not allowed in transactions are
+	// executed without transaction, all other consecutive commands are executed within a transaction.
+	NonTransactionalSplit
+)
+
+// NonTransactionalCommandError signals a migration command that is not allowed within transactions.
+type NonTransactionalCommandError struct {
+	// Version is the version of the migration, 0 if unknown.
+	Version uint64
+	// Index is the position of the command within the migration.
+	Index int
+	// Command is the name of the command.
+	Command string
+	// Reason describes why the command is not allowed.
+	Reason string
+}
+
+// Error implements error interface.
+func (e NonTransactionalCommandError) Error() string {
+	return fmt.Sprintf("command %s at index %d of migration %d can not be executed in a transaction: %s",
+		e.Command, e.Index, e.Version, e.Reason)
+}
+
+// migrationSegment is a part of a migration that is either executed within a transaction or without.
+type migrationSegment struct {
+	Transactional bool
+	Commands      []migrationCommand
+}
+
+// splitMigration splits the commands into segments. Each command that is not allowed in transactions forms its own
+// non-transactional segment, all other consecutive commands are grouped into transactional segments.
+func splitMigration(cmds []migrationCommand) []migrationSegment {
+	var segments []migrationSegment
+	for _, cmd := range cmds {
+		transactional := transactionRestriction(cmd.Command) == ""
+		last := len(segments) - 1
+		if transactional && last >= 0 && segments[last].Transactional {
+			segments[last].Commands = append(segments[last].Commands, cmd)
+			continue
+		}
+		segments = append(segments, migrationSegment{Transactional: transactional, Commands: []migrationCommand{cmd}})
+	}
+	return segments
+}
+
+// checkTransactionRestrictions returns a NonTransactionalCommandError for the first command that is not allowed
+// within transactions.
+func (d *driver) checkTransactionRestrictions(cmds []migrationCommand) error {
+	for _, cmd := range cmds {
+		if reason := transactionRestriction(cmd.Command); reason != "" {
+			err := NonTransactionalCommandError{Index: cmd.Index, Command: commandName(cmd.Command), Reason: reason}
+			if d.pending != nil {
+				err.Version = d.pending.Version
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// executeTransactional runs the migration in transaction mode. Commands that are not allowed within transactions
+// are handled according to the configured NonTransactionalPolicy.
+func (d *driver) executeTransactional(ctx context.Context, cmds []migrationCommand, fn MigrationFunc) error {
+	if d.cfg.Transaction.NonTransactionalPolicy != NonTransactionalSplit {
+		if err := d.checkTransactionRestrictions(cmds); err != nil {
+			return err
+		}
+		return d.executeCommandsWithTransaction(ctx, cmds, fn, true)
+	}
+
+	segments := splitMigration(cmds)
+	last := len(segments) - 1
+	if (last < 0 || !segments[last].Transactional) && (fn != nil || d.cfg.Transaction.IncludeVersion) {
+		// the go migration and the version update are always run in the final transaction
+		segments = append(segments, migrationSegment{Transactional: true})
+		last++
+	}
+
+	for i, segment := range segments {
+		switch {
+		case !segment.Transactional:
+			d.logVerbose("executing command %s without transaction", commandName(segment.Commands[0].Command))
+			if err := d.executeCommands(ctx, segment.Commands); err != nil {
+				return err
+			}
+		case i == last:
+			if err := d.executeCommandsWithTransaction(ctx, segment.Commands, fn, true); err != nil {
+				return err
+			}
+		default:
+			if err := d.executeCommandsWithTransaction(ctx, segment.Commands, nil, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// executeCommandsWithTransaction runs the migration, or a transactional segment of a split migration, within a
+// transaction. The progress is recorded within the same transaction, so that committed commands are skipped when the
+// migration is resumed. The final transaction also runs the go migration, marks the migration as committed and,
+// if enabled, stores the new migration version.
+func (d *driver) executeCommandsWithTransaction(ctx context.Context, cmds []migrationCommand, fn MigrationFunc,
+	final bool) error {
+	completed := len(cmds)
+	if d.progress != nil {
+		completed += d.progress.Completed
+	}
+
+	err := d.runTransaction(ctx, func(txnContext mongo.SessionContext) error {
+		if err := d.executeMigration(txnContext, cmds, fn); err != nil {
+			return err
+		}
+		if err := d.saveProgress(txnContext, completed, final); err != nil {
+			return err
+		}
+		if final {
+			return d.saveVersionInTransaction(txnContext)
+		}
+		return nil
+	})
+	if err == nil && d.progress != nil {
+		// the transaction might have been retried, so only update after the commit
+		d.progress.Completed = completed
+		d.progress.Committed = final
+	}
+	return err
+}
@@ -0,0 +1,165 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func Test_transactionRestriction(t *testing.T) {
+	tests := []struct {
+		name    string
+		cmd     bson.D
+		allowed bool
+	}{
+		{name: "Ping", cmd: bson.D{{Key: "ping", Value: 1}}, allowed: false},
+		{name: "Insert", cmd: bson.D{{Key: "insert", Value: "users"}}, allowed: true},
+		{name: "CreateIndexes", cmd: bson.D{{Key: "createIndexes", Value: "users"}}, allowed: true},
+		{name: "CreateUser", cmd: bson.D{{Key: "createUser", Value: "app"}}, allowed: false},
+		{name: "DropDatabase", cmd: bson.D{{Key: "dropDatabase", Value: 1}}, allowed: false},
+		{name: "Find", cmd: bson.D{{Key: "find", Value: "users"}}, allowed: true},
+		{name: "Count", cmd: bson.D{{Key: "count", Value: "users"}}, allowed: false},
+		{name: "RenameSameDatabase", cmd: bson.D{{Key: "renameCollection", Value: "app.users"}, {Key: "to", Value: "app.accounts"}}, allowed: false},
+		{name: "RenameOtherDatabase", cmd: bson.D{{Key: "renameCollection", Value: "app.users"}, {Key: "to", Value: "archive.users"}}, allowed: false},
+		{name: "Aggregate", cmd: bson.D{{Key: "aggregate", Value: "users"}, {Key: "pipeline", Value: bson.A{bson.D{{Key: "$match", Value: bson.D{}}}}}}, allowed: true},
+		{name: "AggregateOut", cmd: bson.D{{Key: "aggregate", Value: "users"}, {Key: "pipeline", Value: bson.A{bson.D{{Key: "$out", Value: "archive"}}}}}, allowed: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if reason := transactionRestriction(tt.cmd); (reason == "") != tt.allowed {
+				t.Fatalf("unexpected restriction: %q", reason)
+			}
+		})
+	}
+}
+
+func Test_splitMigration(t *testing.T) {
+	segments := splitMigration([]migrationCommand{
+		{Command: bson.D{{Key: "insert", Value: "users"}}},
+		{Command: bson.D{{Key: "update", Value: "users"}}},
+		{Command: bson.D{{Key: "createUser", Value: "app"}}},
+		{Command: bson.D{{Key: "dropDatabase", Value: 1}}},
+		{Command: bson.D{{Key: "delete", Value: "users"}}},
+	})
+
+	want := []struct {
+		transactional bool
+		commands      int
+	}{{true, 2}, {false, 1}, {false, 1}, {true, 1}}
+	if len(segments) != len(want) {
+		t.Fatalf("unexpected segments: %v", segments)
+	}
+	for i, segment := range segments {
+		if segment.Transactional != want[i].transactional || len(segment.Commands) != want[i].commands {
+			t.Fatalf("unexpected segment %d: %v", i, segment)
+		}
+	}
+}
+
+func Test_driver_executeTransactional(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	cmds := []migrationCommand{
+		{Command: bson.D{{Key: "insert", Value: "users"}}, Index: 0},
+		{Command: bson.D{{Key: "createUser", Value: "app"}}, Index: 1},
+		{Command: bson.D{{Key: "find", Value: "users"}}, Index: 2},
+	}
+
+	mt.Run("Fail", func(mt *mtest.T) {
+		d, err := NewDriver(mt.Client, "test", WithTransactions(true))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		d.(*driver).pending = &pendingMigration{Version: 3}
+
+		err = d.(*driver).executeTransactional(context.Background(), cmds, nil)
+		var txnErr NonTransactionalCommandError
+		if !errors.As(err, &txnErr) {
+			t.Fatalf("expected NonTransactionalCommandError, got: %v", err)
+		}
+		if txnErr.Version != 3 || txnErr.Index != 1 || txnErr.Command != "createUser" {
+			t.Fatalf("unexpected error: %v", txnErr)
+		}
+		if started := mt.GetStartedEvent(); started != nil {
+			t.Fatalf("expected no command to be executed, got: %s", started.CommandName)
+		}
+	})
+
+	mt.Run("Split", func(mt *mtest.T) {
+		d, err := NewDriver(mt.Client, "test", WithTransactions(true),
+			WithTransactionConfig(TransactionConfig{NonTransactionalPolicy: NonTransactionalSplit}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // insert
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // commit
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // createUser
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // find
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // commit
+
+		if err = d.(*driver).executeTransactional(context.Background(), cmds, nil); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		want := []struct {
+			name          string
+			transactional bool
+		}{{"insert", true}, {"commitTransaction", true}, {"createUser", false}, {"find", true}, {"commitTransaction", true}}
+		for _, w := range want {
+			started := mt.GetStartedEvent()
+			if started == nil || started.CommandName != w.name {
+				t.Fatalf("expected %s, got: %v", w.name, started)
+			}
+			if _, lookupErr := started.Command.LookupErr("txnNumber"); (lookupErr == nil) != w.transactional {
+				t.Fatalf("unexpected transaction state of %s: %v", w.name, started.Command)
+			}
+		}
+	})
+
+	mt.Run("SplitProgress", func(mt *mtest.T) {
+		d, err := NewDriver(mt.Client, "test", WithTransactions(true),
+			WithTransactionConfig(TransactionConfig{NonTransactionalPolicy: NonTransactionalSplit}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		d.(*driver).progress = &migrationProgress{Version: 3, Direction: "up"}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // insert
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // migration progress
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // commit
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // createUser
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // migration progress
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 0}}) // find
+
+		if err = d.(*driver).executeTransactional(context.Background(), cmds, nil); err == nil {
+			t.Fatalf("expected error, got: %v", err)
+		}
+
+		want := []struct {
+			name      string
+			completed int32
+		}{{"insert", 0}, {"update", 1}, {"commitTransaction", 0}, {"createUser", 0}, {"update", 2}}
+		for _, w := range want {
+			started := mt.GetStartedEvent()
+			if started == nil || started.CommandName != w.name {
+				t.Fatalf("expected %s, got: %v", w.name, started)
+			}
+			if w.completed == 0 {
+				continue
+			}
+			completed, lookupErr := started.Command.LookupErr("updates", "0", "u", "$set", "progress.completed")
+			if lookupErr != nil || completed.Int32() != w.completed {
+				t.Fatalf("unexpected progress update: %v", started.Command)
+			}
+		}
+		if d.(*driver).progress.Completed != 2 {
+			t.Fatalf("unexpected progress: %+v", d.(*driver).progress)
+		}
+	})
+}
@@ -0,0 +1,161 @@
+package mongodb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"gopkg.in/yaml.v3"
+)
+
+// parseYAMLMigration parses and validates a YAML migration file. The structure is the same as for JSON migrations:
+// the top level element must be a list of command documents. Each command is converted to extended JSON, so type
+// markers like $date, $oid or $numberLong result in the same BSON types as in JSON migrations.
+func parseYAMLMigration(migr []byte) ([]migrationCommand, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(migr, &root); err != nil {
+		return nil, MigrationFormatError{Index: -1, Msg: "malformed yaml", Err: err}
+	}
+	if len(root.Content) == 0 || root.Content[0].Kind != yaml.SequenceNode {
+		line, column := yamlPosition(&root)
+		return nil, MigrationFormatError{Index: -1, Line: line, Column: column,
+			Msg: "top level element must be a list of commands"}
+	}
+
+	var cmds []migrationCommand
+	for index, element := range root.Content[0].Content {
+		element = resolveYAMLAlias(element)
+		if element.Kind != yaml.MappingNode {
+			return nil, newYAMLFormatError(element, index, "command must be a document", nil)
+		}
+
+		var buf bytes.Buffer
+		if err := writeYAMLAsJSON(&buf, element); err != nil {
+			return nil, newYAMLFormatError(element, index, "unsupported yaml value", err)
+		}
+
+		var doc bson.D
+		if err := bson.UnmarshalExtJSON(buf.Bytes(), true, &doc); err != nil {
+			return nil, newYAMLFormatError(element, index, "invalid extended json", err)
+		}
+		cmd, msg := unwrapCommand(doc)
+		if msg != "" {
+			return nil, newYAMLFormatError(element, index, msg, nil)
+		}
+		cmd.Line, cmd.Column = element.Line, element.Column
+
+		cmds = append(cmds, cmd)
+	}
+
+	return cmds, nil
+}
+
+// newYAMLFormatError creates a MigrationFormatError for the position of the given node.
+func newYAMLFormatError(node *yaml.Node, index int, msg string, err error) MigrationFormatError {
+	return MigrationFormatError{Index: index, Line: node.Line, Column: node.Column, Msg: msg, Err: err}
+}
+
+// yamlPosition returns the position of the first element of the YAML document, or 0, 0 if the document is empty.
+func yamlPosition(root *yaml.Node) (line, column int) {
+	if len(root.Content) == 0 {
+		return 0, 0
+	}
+	return root.Content[0].Line, root.Content[0].Column
+}
+
+// resolveYAMLAlias returns the node an alias refers to.
+func resolveYAMLAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	return node
+}
+
+// writeYAMLAsJSON writes the YAML node as JSON, keeping the order of mapping keys.
+func writeYAMLAsJSON(buf *bytes.Buffer, node *yaml.Node) error {
+	node = resolveYAMLAlias(node)
+	switch node.Kind {
+	case yaml.MappingNode:
+		buf.WriteByte('{')
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := resolveYAMLAlias(node.Content[i])
+			if key.Kind != yaml.ScalarNode {
+				return fmt.Errorf("line %d: keys must be scalars", key.Line)
+			}
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeJSONString(buf, key.Value)
+			buf.WriteByte(':')
+			if err := writeYAMLAsJSON(buf, node.Content[i+1]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case yaml.SequenceNode:
+		buf.WriteByte('[')
+		for i, child := range node.Content {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeYAMLAsJSON(buf, child); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case yaml.ScalarNode:
+		return writeYAMLScalarAsJSON(buf, node)
+	default:
+		return fmt.Errorf("line %d: unsupported node", node.Line)
+	}
+	return nil
+}
+
+// writeYAMLScalarAsJSON writes a scalar as JSON value. Numbers are written the way they would appear in a JSON
+// migration: integers without and floats with fraction, so they are decoded to the same BSON types.
+func writeYAMLScalarAsJSON(buf *bytes.Buffer, node *yaml.Node) error {
+	switch node.ShortTag() {
+	case "!!null":
+		buf.WriteString("null")
+	case "!!bool":
+		var b bool
+		if err := node.Decode(&b); err != nil {
+			return err
+		}
+		buf.WriteString(strconv.FormatBool(b))
+	case "!!int":
+		var i int64
+		if err := node.Decode(&i); err != nil {
+			return err
+		}
+		buf.WriteString(strconv.FormatInt(i, 10))
+	case "!!float":
+		var f float64
+		if err := node.Decode(&f); err != nil {
+			return err
+		}
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return fmt.Errorf("line %d: %s can not be represented in json, use $numberDouble", node.Line, node.Value)
+		}
+		s := strconv.FormatFloat(f, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".eE") {
+			s += ".0"
+		}
+		buf.WriteString(s)
+	case "!!str", "!!timestamp":
+		writeJSONString(buf, node.Value)
+	default:
+		return fmt.Errorf("line %d: unsupported tag %s", node.Line, node.ShortTag())
+	}
+	return nil
+}
+
+// writeJSONString writes s as quoted JSON string.
+func writeJSONString(buf *bytes.Buffer, s string) {
+	quoted, _ := json.Marshal(s) // marshalling a string can not fail
+	buf.Write(quoted)
+}
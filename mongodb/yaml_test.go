@@ -0,0 +1,102 @@
+package mongodb
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_parseYAMLMigration(t *testing.T) {
+	cmds, err := parseYAMLMigration([]byte(`
+# create the admin user
+- insert: users
+  documents:
+    - _id: {$oid: "61cf8a0c5b2f4a3c9e6d7f10"}
+      created: {$date: {$numberLong: "1640995200000"}}
+      logins: {$numberLong: "5"}
+      score: 1.0
+      ratio: 0.5
+      age: 42
+      active: true
+      deleted: null
+      since: 2022-01-01
+- $db: admin
+  command:
+    renameCollection: app.users
+    to: app.accounts
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want, err := parseMigration([]byte(`[
+  {"insert": "users", "documents": [{
+    "_id": {"$oid": "61cf8a0c5b2f4a3c9e6d7f10"},
+    "created": {"$date": {"$numberLong": "1640995200000"}},
+    "logins": {"$numberLong": "5"},
+    "score": 1.0,
+    "ratio": 0.5,
+    "age": 42,
+    "active": true,
+    "deleted": null,
+    "since": "2022-01-01"
+  }]},
+  {"$db": "admin", "command": {"renameCollection": "app.users", "to": "app.accounts"}}
+]`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if cmds[1].Line != 14 || cmds[1].Column != 3 {
+		t.Fatalf("unexpected position: line %d, column %d", cmds[1].Line, cmds[1].Column)
+	}
+	for i := range cmds { // positions differ between the files
+		cmds[i].Line, cmds[i].Column = 0, 0
+		want[i].Line, want[i].Column = 0, 0
+	}
+	if !reflect.DeepEqual(cmds, want) {
+		t.Fatalf("yaml and json commands differ:\n%v\n%v", cmds, want)
+	}
+}
+
+func Test_parseYAMLMigration_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		migr   string
+		index  int
+		line   int
+		column int
+		msg    string
+	}{
+		{name: "Malformed", migr: "- ping: 1\n  - x", index: -1, msg: "malformed yaml"},
+		{name: "NoList", migr: "ping: 1", index: -1, line: 1, column: 1, msg: "top level element must be a list of commands"},
+		{name: "Empty", migr: "# nothing", index: -1, msg: "top level element must be a list of commands"},
+		{name: "NoDocument", migr: "- ping: 1\n- ping", index: 1, line: 2, column: 3, msg: "command must be a document"},
+		{name: "ExtendedJson", migr: "- insert: users\n  documents: [{n: {$numberLong: x}}]", index: 0, line: 1, column: 3, msg: "invalid extended json"},
+		{name: "Infinity", migr: "- insert: users\n  documents: [{n: .inf}]", index: 0, line: 1, column: 3, msg: "unsupported yaml value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseYAMLMigration([]byte(tt.migr))
+			var formatErr MigrationFormatError
+			if !errors.As(err, &formatErr) {
+				t.Fatalf("expected MigrationFormatError, got: %v", err)
+			}
+			if formatErr.Index != tt.index || formatErr.Line != tt.line || formatErr.Column != tt.column {
+				t.Fatalf("unexpected position: %d (line %d, column %d)", formatErr.Index, formatErr.Line, formatErr.Column)
+			}
+			if !strings.Contains(formatErr.Msg, tt.msg) {
+				t.Fatalf("unexpected message: %s", formatErr.Msg)
+			}
+		})
+	}
+}
+
+func Test_decodeMigration_YAML(t *testing.T) {
+	cmds, err := decodeMigration([]byte("---\n- ping: 1\n"), "test")
+	if err != nil || len(cmds) != 1 || commandName(cmds[0].Command) != "ping" {
+		t.Fatalf("unexpected yaml result: %v, %v", cmds, err)
+	}
+}